@@ -0,0 +1,126 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	CronWorkflowKindName = "CronWorkflow"
+)
+
+// ConcurrencyPolicy defines what happens when a run is due while the previous one is still running.
+// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyPolicyAllow starts the run alongside the running ones.
+	ConcurrencyPolicyAllow ConcurrencyPolicy = "Allow"
+	// ConcurrencyPolicyForbid skips the run, the next one starts at its own schedule.
+	ConcurrencyPolicyForbid ConcurrencyPolicy = "Forbid"
+	// ConcurrencyPolicyReplace deletes the running workflows and starts the run.
+	ConcurrencyPolicyReplace ConcurrencyPolicy = "Replace"
+)
+
+// CronWorkflowSpec defines the desired state of CronWorkflow
+type CronWorkflowSpec struct {
+	// Schedule is a cron expression such as `0 2 * * *`, descriptors like `@daily` are supported.
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+	// TimeZone is the IANA name of the time zone the schedule is interpreted in, the time zone of
+	// the controller by default.
+	TimeZone string `json:"timeZone,omitempty"`
+	// ConcurrencyPolicy is Allow by default.
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// StartingDeadlineSeconds is how late a run may start when it was missed, for instance while
+	// the controller was down. Missed runs are started whatever their delay when unset.
+	// +kubebuilder:validation:Minimum=0
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// Suspend stops starting new runs, running workflows are left to finish.
+	Suspend bool `json:"suspend,omitempty"`
+	// SuccessfulRunsHistoryLimit is the number of successful workflows kept, 3 by default.
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulRunsHistoryLimit *int32 `json:"successfulRunsHistoryLimit,omitempty"`
+	// FailedRunsHistoryLimit is the number of failed or cancelled workflows kept, 1 by default.
+	// +kubebuilder:validation:Minimum=0
+	FailedRunsHistoryLimit *int32 `json:"failedRunsHistoryLimit,omitempty"`
+	// WorkflowSpec is the spec of the workflows created on schedule.
+	WorkflowSpec WorkflowSpec `json:"workflowSpec"`
+}
+
+func (c *CronWorkflowSpec) GetConcurrencyPolicy() ConcurrencyPolicy {
+	if c.ConcurrencyPolicy == "" {
+		return ConcurrencyPolicyAllow
+	}
+
+	return c.ConcurrencyPolicy
+}
+
+func (c *CronWorkflowSpec) GetSuccessfulRunsHistoryLimit() int {
+	if c.SuccessfulRunsHistoryLimit == nil {
+		return 3
+	}
+
+	return int(*c.SuccessfulRunsHistoryLimit)
+}
+
+func (c *CronWorkflowSpec) GetFailedRunsHistoryLimit() int {
+	if c.FailedRunsHistoryLimit == nil {
+		return 1
+	}
+
+	return int(*c.FailedRunsHistoryLimit)
+}
+
+// CronWorkflowStatus defines the observed state of CronWorkflow
+type CronWorkflowStatus struct {
+	// Active lists the workflows still running.
+	Active []v1.ObjectReference `json:"active,omitempty"`
+	// LastScheduleTime is the time the last run was scheduled for.
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// LastSuccessfulTime is the time the last successful run completed.
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+	Message            string       `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=cwf,categories=ci
+
+// CronWorkflow is the Schema for the cronworkflows API
+type CronWorkflow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   CronWorkflowSpec   `json:"spec,omitempty"`
+	Status CronWorkflowStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// CronWorkflowList contains a list of CronWorkflow
+type CronWorkflowList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CronWorkflow `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&CronWorkflow{}, &CronWorkflowList{})
+}
@@ -15,6 +15,9 @@ limitations under the License.
 */
 
 // Package v1alpha1 contains API Schema definitions for the sky v1alpha1 API group
+//
+// Fields embedding large core types, such as the volumes of a task, are schemaless: their schema
+// would make the CRDs too large, the API server validates them when it creates the Pod.
 // +kubebuilder:object:generate=true
 // +groupName=sky.my.domain
 package v1alpha1
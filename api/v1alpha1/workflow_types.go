@@ -17,13 +17,16 @@ limitations under the License.
 package v1alpha1
 
 import (
+	"encoding/json"
+	"fmt"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"slices"
 	"time"
 )
 
 const (
-	KindName = "workflow"
+	KindName = "Workflow"
 )
 
 type WorkStatus string
@@ -37,50 +40,562 @@ const (
 	WorkFlowStatusPause   WorkStatus = "Pause"
 )
 
+// Workflow condition types, set along the status of the workflow.
+const (
+	// WorkflowConditionReady is false when the controller cannot run the workflow, for instance
+	// because its templates cannot be resolved or its tasks cannot be created.
+	WorkflowConditionReady = "Ready"
+	// WorkflowConditionSucceeded is true once the workflow succeeded, false once it failed or was
+	// cancelled and unknown until then.
+	WorkflowConditionSucceeded = "Succeeded"
+	// WorkflowConditionFailed is true once the workflow failed or was cancelled.
+	WorkflowConditionFailed = "Failed"
+	// WorkflowConditionSuspended is true while the workflow is suspended.
+	WorkflowConditionSuspended = "Suspended"
+)
+
+// Workflow condition reasons.
+const (
+	WorkflowReasonReconciled   = "Reconciled"
+	WorkflowReasonInvalidSpec  = "InvalidSpec"
+	WorkflowReasonCreateFailed = "CreateFailed"
+	WorkflowReasonWaiting      = "Waiting"
+	WorkflowReasonRunning      = "Running"
+	WorkflowReasonSuspended    = "Suspended"
+	WorkflowReasonSucceeded    = "Succeeded"
+	WorkflowReasonFailed       = "Failed"
+	WorkflowReasonCancelled    = "Cancelled"
+)
+
+// TaskPhase is the phase of a task.
+// +kubebuilder:validation:Enum=Pending;Running;Succeeded;Failed;Skipped;Cancelled;Retrying
+type TaskPhase string
+
+const (
+	// TaskStatusPending marks a task whose Pod or child workflow did not start yet.
+	TaskStatusPending TaskPhase = "Pending"
+	// TaskStatusRunning marks a task whose Pod or child workflow runs, or that waits for an approval.
+	TaskStatusRunning   TaskPhase = "Running"
+	TaskStatusSucceeded TaskPhase = "Succeeded"
+	TaskStatusFailed    TaskPhase = "Failed"
+	// TaskStatusSkipped marks a task that will never run because its dependencies can no longer be satisfied.
+	TaskStatusSkipped TaskPhase = "Skipped"
+	// TaskStatusRetrying marks a failed task waiting for its next attempt.
+	TaskStatusRetrying TaskPhase = "Retrying"
+	// TaskStatusCancelled marks a task stopped or never started because the workflow was cancelled.
+	TaskStatusCancelled TaskPhase = "Cancelled"
+)
+
+// StepState is the state of the container of a step.
+// +kubebuilder:validation:Enum=Waiting;Running;Terminated
+type StepState string
+
+const (
+	StepStateWaiting    StepState = "Waiting"
+	StepStateRunning    StepState = "Running"
+	StepStateTerminated StepState = "Terminated"
+)
+
+const (
+	// TaskReasonWhenFalse is set on tasks skipped because their when expression evaluated to false.
+	// Such tasks satisfy the Succeeded dependencies of their dependents.
+	TaskReasonWhenFalse = "WhenConditionFalse"
+	// TaskReasonDependenciesNotSatisfied is set on tasks skipped because of their dependencies.
+	TaskReasonDependenciesNotSatisfied = "DependenciesNotSatisfied"
+	// TaskReasonWaitingForApproval is set on approval tasks no one decided on yet.
+	TaskReasonWaitingForApproval = "WaitingForApproval"
+	// TaskReasonApprovalTimedOut is set on approval tasks that failed because no one decided in time.
+	TaskReasonApprovalTimedOut = "ApprovalTimedOut"
+	// TaskReasonWorkspaceConflict is set on tasks that failed because the claim of one of their
+	// workspaces belongs to another object.
+	TaskReasonWorkspaceConflict = "WorkspaceConflict"
+	// TaskReasonWorkflowConflict is set on tasks that failed because the name of their child
+	// workflow is taken by a workflow they do not control.
+	TaskReasonWorkflowConflict = "WorkflowConflict"
+	// TaskReasonOutputsTooLarge is set on tasks whose outputs fit neither the termination message
+	// nor the ConfigMap of the workflow.
+	TaskReasonOutputsTooLarge = "OutputsTooLarge"
+)
+
+// Approval tasks are decided by setting the ApprovalAnnotationPrefix + `<task>` annotation of the
+// workflow to Approved or Rejected, with an optional comment in ApprovalAnnotationPrefix +
+// `<task>.comment`. The admission webhook checks the user is allowed to decide and records it in
+// ApprovalAnnotationPrefix + `<task>.approver`.
+const ApprovalAnnotationPrefix = "approval.sky.my.domain/"
+
+// ApprovalDecision is the decision taken on an approval task.
+// +kubebuilder:validation:Enum=Approved;Rejected
+type ApprovalDecision string
+
+const (
+	ApprovalApproved ApprovalDecision = "Approved"
+	ApprovalRejected ApprovalDecision = "Rejected"
+)
+
+// ApprovalAnnotation returns the annotation the decision on an approval task is taken with.
+func ApprovalAnnotation(taskName string) string {
+	return ApprovalAnnotationPrefix + taskName
+}
+
+// ApprovalCommentAnnotation returns the annotation commenting the decision on an approval task.
+func ApprovalCommentAnnotation(taskName string) string {
+	return ApprovalAnnotation(taskName) + ".comment"
+}
+
+// ApproverAnnotation returns the annotation recording the user who decided on an approval task.
+func ApproverAnnotation(taskName string) string {
+	return ApprovalAnnotation(taskName) + ".approver"
+}
+
+// DependencyCondition is the outcome of an upstream task that satisfies a dependency.
+// +kubebuilder:validation:Enum=Succeeded;Failed;Always
+type DependencyCondition string
+
+const (
+	DependencySucceeded DependencyCondition = "Succeeded"
+	DependencyFailed    DependencyCondition = "Failed"
+	DependencyAlways    DependencyCondition = "Always"
+)
+
+// DependencyPolicy defines how many dependencies have to be satisfied before a task starts.
+// +kubebuilder:validation:Enum=All;Any
+type DependencyPolicy string
+
+const (
+	DependencyPolicyAll DependencyPolicy = "All"
+	DependencyPolicyAny DependencyPolicy = "Any"
+)
+
+// Dependency is an upstream task. The name of the task alone, as in `dependencies: [a, b]`,
+// depends on it succeeding.
+// +kubebuilder:pruning:PreserveUnknownFields
+// +kubebuilder:validation:Type=""
+type Dependency struct {
+	Name string `json:"name"`
+	// Condition is the upstream outcome that satisfies the dependency, Succeeded by default.
+	// Failed runs the task only when the upstream task failed, Always once it finished whatever the outcome.
+	Condition DependencyCondition `json:"condition,omitempty"`
+}
+
+// UnmarshalJSON accepts the name of the upstream task as well as a dependency object.
+func (d *Dependency) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*d = Dependency{Name: name}
+		return nil
+	}
+	type dependency Dependency
+	var value dependency
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("dependency %s is neither a task name nor a dependency object: %v", data, err)
+	}
+	*d = Dependency(value)
+	return nil
+}
+
+func (d *Dependency) GetCondition() DependencyCondition {
+	if d.Condition == "" {
+		return DependencySucceeded
+	}
+
+	return d.Condition
+}
+
 type Step struct {
 	Name        string `json:"name"`
 	DisplayName string `json:"displayName,omitempty"`
 	Description string `json:"description,omitempty"`
-	Image       string `json:"image"`
-	Script      string `json:"script"`
-	Args        string `json:"args,omitempty"`
+	// Image is the image of the step, the defaulting webhook fills in the default image of the
+	// controller configuration when it is empty.
+	Image  string `json:"image,omitempty"`
+	Script string `json:"script"`
+	// Args are the arguments of the script, separated by commas.
+	Args string `json:"args,omitempty"`
+	// Stage runs the step together with the adjacent steps of the same stage, the following steps
+	// wait for all of them. Steps without a stage run alone.
+	Stage string `json:"stage,omitempty"`
+	// Env is set in the container of the step, values can reference Secrets and ConfigMaps.
+	// Placeholders are replaced in the plain values like in the script.
+	Env []v1.EnvVar `json:"env,omitempty"`
+	// Resources are the resource requests and limits of the container of the step.
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+	// WorkingDir is the directory the script runs in, the one of the image by default.
+	WorkingDir      string              `json:"workingDir,omitempty"`
+	SecurityContext *v1.SecurityContext `json:"securityContext,omitempty"`
+	// VolumeMounts mount volumes of the task, or of its workspaces, into the container of the step.
+	VolumeMounts []v1.VolumeMount `json:"volumeMounts,omitempty"`
 }
 
+// StageFailurePolicy decides what happens to the other steps of a stage when one of them fails.
+// +kubebuilder:validation:Enum=FailFast;WaitAll
+type StageFailurePolicy string
+
+const (
+	// StageFailFast stops the other steps of the stage.
+	StageFailFast StageFailurePolicy = "FailFast"
+	// StageWaitAll lets the other steps of the stage finish.
+	StageWaitAll StageFailurePolicy = "WaitAll"
+)
+
 type Task struct {
-	Name         string           `json:"name"`
-	DisplayName  string           `json:"displayName,omitempty"`
-	Description  string           `json:"description,omitempty"`
-	Dependencies []string         `json:"dependencies,omitempty"`
-	Outputs      []TaskOutput     `json:"outputs,omitempty"`
-	Timeout      *metav1.Duration `json:"timeout,omitempty"`
-	Steps        []Step           `json:"steps"`
+	Name         string       `json:"name"`
+	DisplayName  string       `json:"displayName,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	Dependencies []Dependency `json:"dependencies,omitempty"`
+	// DependencyPolicy is All by default: the task starts once every dependency is satisfied.
+	// With Any it starts as soon as one of them is.
+	DependencyPolicy DependencyPolicy `json:"dependencyPolicy,omitempty"`
+	// When is a CEL expression evaluated once the dependencies are satisfied, the task is skipped
+	// when it is false. It can read the workflow inputs as `inputs.<name>` and upstream tasks as
+	// `tasks.<name>.status` and `tasks.<name>.outputs.<output>`, all values are strings. Expressions
+	// whose cost exceeds the limit of the API server validation rules fail the task.
+	When string `json:"when,omitempty"`
+	// WithItems runs one instance of the task per item, `{{item}}` is replaced by the item in the
+	// script and args of the steps.
+	WithItems []string `json:"withItems,omitempty"`
+	// WithParam runs one instance of the task per element of a JSON array, usually an upstream
+	// output such as `{{tasks.<name>.outputs.<output>}}`. Object elements also expose their
+	// fields as `{{item.<field>}}`.
+	WithParam string `json:"withParam,omitempty"`
+	// Matrix runs one instance of the task per combination of its parameters.
+	Matrix        *Matrix          `json:"matrix,omitempty"`
+	Outputs       []TaskOutput     `json:"outputs,omitempty"`
+	Timeout       *metav1.Duration `json:"timeout,omitempty"`
+	RetryStrategy *RetryStrategy   `json:"retryStrategy,omitempty"`
+	Steps         []Step           `json:"steps,omitempty"`
+	// StageFailurePolicy applies to the steps running in parallel stages, FailFast by default. The
+	// stages after a failed step never start.
+	StageFailurePolicy StageFailurePolicy `json:"stageFailurePolicy,omitempty"`
+	// TemplateRef runs a task of a template. The fields set on the task override the ones of the
+	// template task, steps and outputs included.
+	TemplateRef *TaskTemplateRef `json:"templateRef,omitempty"`
+	// Workspaces mounts workspaces of the workflow into every step of the task.
+	Workspaces []WorkspaceBinding `json:"workspaces,omitempty"`
+	// Artifacts are directories passed between tasks through the artifact repository of the controller.
+	Artifacts *TaskArtifacts `json:"artifacts,omitempty"`
+	// Sidecars are services, such as a database, running next to the steps and reachable on
+	// localhost. They start in order before the first step, a startupProbe holds the steps until the
+	// service is ready, and are stopped once the last step finished. They run as native sidecar
+	// containers, which needs Kubernetes 1.29 or later.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Sidecars []v1.Container `json:"sidecars,omitempty"`
+	// Approval waits for a user to approve or reject the task instead of running steps, no Pod is
+	// created. The task succeeds when approved and fails when rejected or timed out.
+	Approval *Approval `json:"approval,omitempty"`
+	// Workflow runs a child workflow instead of steps. The task completes with the child and its
+	// outputs are the outputs of the child. Retry strategies do not apply to child workflows.
+	Workflow *SubWorkflow `json:"workflow,omitempty"`
+	// PodTemplate configures the Pod of the task, over the pod template of the workflow.
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+	// Volumes are added to the Pod of the task, for the volumeMounts of its steps.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Volumes []v1.Volume `json:"volumes,omitempty"`
+}
+
+func (t *Task) GetDependencyPolicy() DependencyPolicy {
+	if t.DependencyPolicy == "" {
+		return DependencyPolicyAll
+	}
+
+	return t.DependencyPolicy
+}
+
+func (t *Task) GetStageFailurePolicy() StageFailurePolicy {
+	if t.StageFailurePolicy == "" {
+		return StageFailFast
+	}
+
+	return t.StageFailurePolicy
+}
+
+// IsFanOut reports whether the task runs as several instances.
+func (t Task) IsFanOut() bool {
+	return len(t.WithItems) != 0 || t.WithParam != "" || t.Matrix != nil
+}
+
+type Matrix struct {
+	// Parameters maps every parameter to its values, the task runs once per combination with the
+	// values available as `{{matrix.<name>}}`.
+	Parameters map[string][]MatrixValue `json:"parameters,omitempty"`
+	// Include adds combinations to the product.
+	Include []map[string]MatrixValue `json:"include,omitempty"`
+	// Exclude removes the combinations matching every value of an entry.
+	Exclude []map[string]MatrixValue `json:"exclude,omitempty"`
+	// MaxParallel caps the number of instances running at the same time, unlimited when 0.
+	// +kubebuilder:validation:Minimum=0
+	MaxParallel int32 `json:"maxParallel,omitempty"`
+}
+
+// MatrixValue is a value of a matrix parameter. Numbers and booleans are accepted as written in
+// JSON, YAML renders `1.20` as `1.2` though, such values have to be quoted.
+// +kubebuilder:pruning:PreserveUnknownFields
+// +kubebuilder:validation:Type=""
+type MatrixValue string
+
+// UnmarshalJSON accepts a string, a number or a boolean.
+func (v *MatrixValue) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case string:
+		*v = MatrixValue(value)
+	case float64, bool:
+		*v = MatrixValue(data)
+	default:
+		return fmt.Errorf("matrix value %s is not a string, a number or a boolean", data)
+	}
+	return nil
 }
 
+// DefaultTaskTimeout is the timeout of the tasks without one, when the defaulting webhook did not
+// set it.
+const DefaultTaskTimeout = 60 * time.Minute
+
 func (t *Task) GetTimeout() time.Duration {
 	if t.Timeout == nil {
-		return 60 * time.Minute
+		return DefaultTaskTimeout
 	}
 
 	return t.Timeout.Duration
 }
 
+// RetryReason is a cause of Pod failure that can be retried.
+// +kubebuilder:validation:Enum=Evicted;OOMKilled;DeadlineExceeded;Error
+type RetryReason string
+
+const (
+	RetryReasonEvicted          RetryReason = "Evicted"
+	RetryReasonOOMKilled        RetryReason = "OOMKilled"
+	RetryReasonDeadlineExceeded RetryReason = "DeadlineExceeded"
+	// RetryReasonError is a step exiting with a non-zero code.
+	RetryReasonError RetryReason = "Error"
+)
+
+type RetryStrategy struct {
+	// Limit is the number of retries after the first attempt.
+	// +kubebuilder:validation:Minimum=0
+	Limit   int32         `json:"limit"`
+	Backoff *RetryBackoff `json:"backoff,omitempty"`
+	// RetryOn lists the failure reasons that are retried. When neither RetryOn nor ExitCodes is set
+	// every failure is retried, otherwise failures matching none of them are not.
+	RetryOn []RetryReason `json:"retryOn,omitempty"`
+	// ExitCodes lists the step exit codes that are retried.
+	ExitCodes []int32 `json:"exitCodes,omitempty"`
+}
+
+type RetryBackoff struct {
+	// Duration is the delay before the first retry.
+	Duration *metav1.Duration `json:"duration,omitempty"`
+	// Factor multiplies the delay after every retry, 2 by default.
+	// +kubebuilder:validation:Minimum=1
+	Factor *int32 `json:"factor,omitempty"`
+	// MaxDuration caps the delay between two attempts, 1h by default.
+	MaxDuration *metav1.Duration `json:"maxDuration,omitempty"`
+}
+
+// WorkspaceReclaimPolicy defines what happens to the claim of a workspace once the workflow finished.
+// +kubebuilder:validation:Enum=Delete;Retain
+type WorkspaceReclaimPolicy string
+
+const (
+	// WorkspaceReclaimDelete deletes the claim when the workflow finishes.
+	WorkspaceReclaimDelete WorkspaceReclaimPolicy = "Delete"
+	// WorkspaceReclaimRetain keeps the claim until the workflow is deleted.
+	WorkspaceReclaimRetain WorkspaceReclaimPolicy = "Retain"
+)
+
+// Workspace is a volume shared by the tasks of a workflow.
+type Workspace struct {
+	Name string `json:"name"`
+	// VolumeClaimTemplate is the spec of the PersistentVolumeClaim created for every run of the
+	// workflow. The claim has to allow the access mode its tasks need, tasks running at the same
+	// time on different nodes need ReadWriteMany.
+	VolumeClaimTemplate v1.PersistentVolumeClaimSpec `json:"volumeClaimTemplate"`
+	// ReclaimPolicy is Delete by default.
+	ReclaimPolicy WorkspaceReclaimPolicy `json:"reclaimPolicy,omitempty"`
+}
+
+func (w *Workspace) GetReclaimPolicy() WorkspaceReclaimPolicy {
+	if w.ReclaimPolicy == "" {
+		return WorkspaceReclaimDelete
+	}
+
+	return w.ReclaimPolicy
+}
+
+type WorkspaceBinding struct {
+	// Name is the name of a workspace of the workflow.
+	Name string `json:"name"`
+	// MountPath is `/workspace/<name>` by default.
+	MountPath string `json:"mountPath,omitempty"`
+	// SubPath mounts a directory of the workspace instead of its root.
+	SubPath  string `json:"subPath,omitempty"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+func (w *WorkspaceBinding) GetMountPath() string {
+	if w.MountPath == "" {
+		return fmt.Sprintf("/workspace/%s", w.Name)
+	}
+
+	return w.MountPath
+}
+
+type TaskArtifacts struct {
+	// Inputs are downloaded before the first step starts.
+	Inputs []Artifact `json:"inputs,omitempty"`
+	// Outputs are uploaded once the last step succeeded.
+	Outputs []Artifact `json:"outputs,omitempty"`
+}
+
+type Artifact struct {
+	Name string `json:"name"`
+	// Path is a directory shared by the steps of the task, the artifact is its content.
+	Path string `json:"path"`
+	// From is the output artifact of an upstream task an input artifact is downloaded from.
+	From *ArtifactSource `json:"from,omitempty"`
+}
+
+type ArtifactSource struct {
+	Task     string `json:"task"`
+	Artifact string `json:"artifact"`
+}
+
+// PodTemplate configures the Pods of the tasks. The template of a task is merged over the one of
+// the workflow: maps are merged key by key, the other fields set on the task replace the workflow ones.
+type PodTemplate struct {
+	// Labels and Annotations are added to the Pods, the ones set by the controller take precedence.
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations  []v1.Toleration   `json:"tolerations,omitempty"`
+	// Affinity is the affinity of the Pod.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Affinity           *v1.Affinity              `json:"affinity,omitempty"`
+	ServiceAccountName string                    `json:"serviceAccountName,omitempty"`
+	ImagePullSecrets   []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	PriorityClassName  string                    `json:"priorityClassName,omitempty"`
+	RuntimeClassName   *string                   `json:"runtimeClassName,omitempty"`
+	HostAliases        []v1.HostAlias            `json:"hostAliases,omitempty"`
+}
+
+// Approval is the decision an approval task waits for.
+type Approval struct {
+	// Message is shown to the approvers.
+	Message string `json:"message,omitempty"`
+	// Timeout fails the task when no decision was taken in time, unlimited by default.
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+	// Groups restricts the decision to the members of one of the groups. The approvers always need
+	// the approve verb on the workflow.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// ApprovalStatus records the decision taken on an approval task.
+type ApprovalStatus struct {
+	// RequestTime is when the task started waiting for a decision.
+	RequestTime *metav1.Time     `json:"requestTime,omitempty"`
+	Decision    ApprovalDecision `json:"decision,omitempty"`
+	Approver    string           `json:"approver,omitempty"`
+	Comment     string           `json:"comment,omitempty"`
+	// DecisionTime is when the decision was observed.
+	DecisionTime *metav1.Time `json:"decisionTime,omitempty"`
+}
+
+// SubWorkflow is the child workflow a task runs, owned by the workflow so that cancellation and
+// deletion cascade to it.
+type SubWorkflow struct {
+	// WorkflowTemplateRef runs the tasks of a template.
+	WorkflowTemplateRef *TemplateRef `json:"workflowTemplateRef,omitempty"`
+	// Spec is the spec of the child workflow, merged over the template when both are set. It is
+	// validated when the child is created.
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:Type=object
+	Spec *WorkflowSpec `json:"spec,omitempty"`
+	// Inputs override the inputs of the child by name, placeholders are replaced in their values.
+	Inputs []Input `json:"inputs,omitempty"`
+}
+
+// WorkflowOutput is an output of a workflow, exposed to the parent of a child workflow.
+type WorkflowOutput struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Value is resolved once the workflow completed, usually from `{{tasks.<name>.outputs.<output>}}`.
+	Value string `json:"value"`
+}
+
 type TaskOutput struct {
 	Name        string `json:"name"`
 	Description string `json:"description,omitempty"`
 }
 
 type TaskStatus struct {
-	Name           string       `json:"name"`
+	Name    string `json:"name"`
+	PodName string `json:"podName"`
+	// Approval records the decision on an approval task.
+	Approval *ApprovalStatus `json:"approval,omitempty"`
+	// WorkflowName is the child workflow of a task running one.
+	WorkflowName string `json:"workflowName,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// Status is the phase of the task.
+	Status TaskPhase `json:"status"`
+	Reason string    `json:"reason,omitempty"`
+	// StartTime is when the task started: its first Pod or its child workflow was created, or its
+	// approval was requested.
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Steps are the states of the containers of the steps of the current attempt, in step order.
+	Steps   []StepStatus `json:"steps,omitempty"`
+	Outputs []*Output    `json:"outputs,omitempty"`
+	// Attempts records the failed attempts that were retried, oldest first.
+	Attempts []TaskAttempt `json:"attempts,omitempty"`
+	// Instances lists the instances a fanned-out task expanded into. The task completes once all of
+	// them completed and exposes each of its outputs as a JSON array of the instance values.
+	Instances []string `json:"instances,omitempty"`
+	// Parent is the fanned-out task an instance belongs to.
+	Parent string `json:"parent,omitempty"`
+	// Parameters are the values substituted into the steps of an instance, keyed by placeholder.
+	Parameters map[string]string `json:"parameters,omitempty"`
+}
+
+// StepStatus is the state of the container of a step.
+type StepStatus struct {
+	Name  string    `json:"name"`
+	State StepState `json:"state,omitempty"`
+	// Reason explains the state, for instance ContainerCreating, Completed, Error, OOMKilled or
+	// DeadlineExceeded when the task timed out.
+	Reason string `json:"reason,omitempty"`
+	// ExitCode is the exit code of a terminated step.
+	ExitCode       *int32       `json:"exitCode,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+type TaskAttempt struct {
 	PodName        string       `json:"podName"`
+	Reason         RetryReason  `json:"reason,omitempty"`
+	ExitCode       *int32       `json:"exitCode,omitempty"`
 	Message        string       `json:"message,omitempty"`
-	Status         v1.PodPhase  `json:"status"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
 	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
-	Outputs        []*Output    `json:"outputs,omitempty"`
 }
 
 type Output struct {
 	Name  string `json:"name,omitempty"`
 	Value string `json:"value,omitempty"`
+	// ValueFrom references where an output too large for the termination message of its step is
+	// stored, Value is empty then.
+	ValueFrom *OutputSource `json:"valueFrom,omitempty"`
+}
+
+type OutputSource struct {
+	// ConfigMapKeyRef selects the key of the ConfigMap, owned by the workflow, holding the output.
+	ConfigMapKeyRef *v1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
 }
 
 type Input struct {
@@ -96,23 +611,68 @@ type WorkflowSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 
-	Inputs []Input `json:"inputs,omitempty"`
-	Tasks  []Task  `json:"tasks"`
+	// WorkflowTemplateRef runs the tasks of a template, the tasks of the workflow are added to
+	// them. Inputs of the workflow override the template inputs with the same name.
+	WorkflowTemplateRef *TemplateRef `json:"workflowTemplateRef,omitempty"`
+	Inputs              []Input      `json:"inputs,omitempty"`
+	Tasks               []Task       `json:"tasks,omitempty"`
+	// Finally tasks run once every task completed, whatever the outcome, cancellation included.
+	// They can depend on each other and on tasks, and read the outcome of the tasks as
+	// `{{workflow.status}}` and the comma-separated names of the failed tasks as `{{workflow.failedTasks}}`.
+	Finally []Task `json:"finally,omitempty"`
+	// Workspaces are volumes shared by the tasks, backed by a PersistentVolumeClaim per run.
+	Workspaces []Workspace `json:"workspaces,omitempty"`
+	// Outputs are resolved once the workflow completed, a workflow running as a child exposes them
+	// as the outputs of the task of its parent.
+	Outputs []WorkflowOutput `json:"outputs,omitempty"`
+	// PodTemplate configures the Pods of every task.
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+	// Suspend stops scheduling new tasks, running tasks are left to finish. Clearing it resumes the
+	// workflow where it stopped.
+	Suspend bool `json:"suspend,omitempty"`
+	// Cancel deletes the running task Pods, marks the remaining tasks as cancelled and finishes the workflow.
+	Cancel bool `json:"cancel,omitempty"`
 }
 
 // WorkflowStatus defines the observed state of Workflow
 type WorkflowStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	Status         WorkStatus            `json:"status"`
-	Message        string                `json:"message,omitempty"`
-	StartTime      *metav1.Time          `json:"startTime,omitempty"`
-	CompletionTime *metav1.Time          `json:"completionTime,omitempty"`
-	TaskStatus     map[string]TaskStatus `json:"taskStatus,omitempty"`
+	Status  WorkStatus `json:"status"`
+	Message string     `json:"message,omitempty"`
+	// ObservedGeneration is the generation of the spec the status was computed from.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready, Succeeded, Failed and Suspended conditions of the workflow.
+	// +listType=map
+	// +listMapKey=type
+	Conditions     []metav1.Condition `json:"conditions,omitempty"`
+	StartTime      *metav1.Time       `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time       `json:"completionTime,omitempty"`
+	// Duration is how long the workflow ran, set once it completed.
+	Duration string `json:"duration,omitempty"`
+	// TotalTasks counts the tasks and finally tasks of the workflow, a fanned-out task counts once.
+	TotalTasks int32 `json:"totalTasks,omitempty"`
+	// CompletedTasks counts the tasks and finally tasks that completed, skipped ones included.
+	CompletedTasks int32 `json:"completedTasks,omitempty"`
+	// Progress is CompletedTasks/TotalTasks, as printed by kubectl.
+	Progress   string                `json:"progress,omitempty"`
+	TaskStatus map[string]TaskStatus `json:"taskStatus,omitempty"`
+	// Outputs are the resolved outputs of the workflow.
+	Outputs []*Output `json:"outputs,omitempty"`
+	// StoredSpec holds the inputs, outputs, workspaces, pod template, tasks and finally tasks of a workflow referencing templates, resolved when the
+	// workflow started. Later template changes do not affect the run.
+	StoredSpec *WorkflowSpec `json:"storedSpec,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=wf,categories=ci
+// +kubebuilder:printcolumn:name="Status",type=string,JSONPath=`.status.status`
+// +kubebuilder:printcolumn:name="Progress",type=string,JSONPath=`.status.progress`
+// +kubebuilder:printcolumn:name="Started",type=date,JSONPath=`.status.startTime`
+// +kubebuilder:printcolumn:name="Duration",type=string,JSONPath=`.status.duration`
+// +kubebuilder:printcolumn:name="Message",type=string,JSONPath=`.status.message`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // Workflow is the Schema for the workflows API
 type Workflow struct {
@@ -132,9 +692,76 @@ type WorkflowList struct {
 	Items           []Workflow `json:"items"`
 }
 
+// GetTasks returns the tasks the workflow runs, the resolved ones when it references templates.
+func (w *Workflow) GetTasks() []Task {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.Tasks
+	}
+
+	return w.Spec.Tasks
+}
+
+// GetFinally returns the finally tasks of the workflow, the resolved ones when it references templates.
+func (w *Workflow) GetFinally() []Task {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.Finally
+	}
+
+	return w.Spec.Finally
+}
+
+// GetWorkspaces returns the workspaces of the workflow, merged with the template ones when it references a template.
+func (w *Workflow) GetWorkspaces() []Workspace {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.Workspaces
+	}
+
+	return w.Spec.Workspaces
+}
+
+// GetInputs returns the inputs of the workflow, merged with the template ones when it references a template.
+func (w *Workflow) GetInputs() []Input {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.Inputs
+	}
+
+	return w.Spec.Inputs
+}
+
+// GetOutputs returns the outputs of the workflow, merged with the template ones when it references a template.
+func (w *Workflow) GetOutputs() []WorkflowOutput {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.Outputs
+	}
+
+	return w.Spec.Outputs
+}
+
+// GetPodTemplate returns the pod template of the workflow, merged with the template one when it references a template.
+func (w *Workflow) GetPodTemplate() *PodTemplate {
+	if w.Status.StoredSpec != nil {
+		return w.Status.StoredSpec.PodTemplate
+	}
+
+	return w.Spec.PodTemplate
+}
+
+// UsesTemplates reports whether the workflow or one of its tasks references a template.
+func (w *Workflow) UsesTemplates() bool {
+	if w.Spec.WorkflowTemplateRef != nil {
+		return true
+	}
+	for _, task := range slices.Concat(w.Spec.Tasks, w.Spec.Finally) {
+		if task.TemplateRef != nil {
+			return true
+		}
+	}
+	return false
+}
+
 func (w *Workflow) ValidateUniqueTaskNames() bool {
 	taskNames := make(map[string]bool)
-	for _, task := range w.Spec.Tasks {
+	for _, task := range slices.Concat(w.GetTasks(), w.GetFinally()) {
 		if taskNames[task.Name] {
 			return true
 		}
@@ -0,0 +1,113 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	WorkflowTemplateKindName        = "WorkflowTemplate"
+	ClusterWorkflowTemplateKindName = "ClusterWorkflowTemplate"
+)
+
+// TemplateKind is the kind of a referenced template.
+// +kubebuilder:validation:Enum=WorkflowTemplate;ClusterWorkflowTemplate
+type TemplateKind string
+
+const (
+	TemplateKindWorkflowTemplate        TemplateKind = WorkflowTemplateKindName
+	TemplateKindClusterWorkflowTemplate TemplateKind = ClusterWorkflowTemplateKindName
+)
+
+type TemplateRef struct {
+	// Kind is WorkflowTemplate by default, a template in the namespace of the workflow.
+	Kind TemplateKind `json:"kind,omitempty"`
+	Name string       `json:"name"`
+}
+
+func (t *TemplateRef) GetKind() TemplateKind {
+	if t.Kind == "" {
+		return TemplateKindWorkflowTemplate
+	}
+
+	return t.Kind
+}
+
+// TaskTemplateRef references a task of a template.
+type TaskTemplateRef struct {
+	TemplateRef `json:",inline"`
+	Task        string `json:"task"`
+}
+
+// WorkflowTemplateSpec defines the inputs and tasks shared by the workflows referencing the template.
+type WorkflowTemplateSpec struct {
+	Inputs []Input `json:"inputs,omitempty"`
+	Tasks  []Task  `json:"tasks"`
+	// Finally tasks run after the tasks of the workflows, before their own finally tasks.
+	Finally []Task `json:"finally,omitempty"`
+	// Workspaces are the workspaces the tasks use, workflows can override them by name.
+	Workspaces []Workspace `json:"workspaces,omitempty"`
+	// Outputs of the workflows, workflows can override them by name.
+	Outputs []WorkflowOutput `json:"outputs,omitempty"`
+	// PodTemplate configures the Pods of the tasks, the pod template of workflows is merged over it.
+	PodTemplate *PodTemplate `json:"podTemplate,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:shortName=wftmpl,categories=ci
+
+// WorkflowTemplate is the Schema for the workflowtemplates API
+type WorkflowTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WorkflowTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WorkflowTemplateList contains a list of WorkflowTemplate
+type WorkflowTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WorkflowTemplate `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=cwftmpl,categories=ci
+
+// ClusterWorkflowTemplate is the Schema for the clusterworkflowtemplates API
+type ClusterWorkflowTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WorkflowTemplateSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterWorkflowTemplateList contains a list of ClusterWorkflowTemplate
+type ClusterWorkflowTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterWorkflowTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WorkflowTemplate{}, &WorkflowTemplateList{}, &ClusterWorkflowTemplate{}, &ClusterWorkflowTemplateList{})
+}
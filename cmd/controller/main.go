@@ -25,10 +25,14 @@ import (
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -37,6 +41,7 @@ import (
 
 	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
 	"github.com/hq0101/workflow/internal/controller"
+	webhooksky "github.com/hq0101/workflow/internal/webhook/v1alpha1"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -59,6 +64,8 @@ func main() {
 	var secureMetrics bool
 	var enableHTTP2 bool
 	var tlsOpts []func(*tls.Config)
+	var artifactRepository controller.ArtifactRepository
+	var workflowDefaultsPath string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
@@ -69,6 +76,17 @@ func main() {
 		"If set, the metrics endpoint is served securely via HTTPS. Use --metrics-secure=false to use HTTP instead.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&artifactRepository.Endpoint, "artifact-endpoint", "",
+		"The host and port of the S3-compatible endpoint storing the task artifacts, for instance minio.minio:9000.")
+	flag.StringVar(&artifactRepository.Bucket, "artifact-bucket", "", "The bucket storing the task artifacts.")
+	flag.StringVar(&artifactRepository.Region, "artifact-region", "", "The region of the artifact bucket.")
+	flag.BoolVar(&artifactRepository.Insecure, "artifact-insecure", false,
+		"If set, the artifact endpoint is reached over HTTP instead of HTTPS.")
+	flag.StringVar(&artifactRepository.CredentialsSecret, "artifact-credentials-secret", "",
+		"The name of the Secret holding the accessKey and secretKey of the artifact bucket, in the namespace of the workflows.")
+	flag.StringVar(&artifactRepository.KeyPrefix, "artifact-key-prefix", "", "The prefix of the artifact keys in the bucket.")
+	flag.StringVar(&workflowDefaultsPath, "workflow-defaults", "",
+		"The YAML file of the task timeout, image, resources and pod template the webhooks fill in when workflows leave them unset.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -127,6 +145,13 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "6fb19f8f.my.domain",
+		// Only task Pods and output ConfigMaps are cached, workspace claims are read from the API server.
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				&corev1.Pod{}:       {Label: controller.TaskPodSelector()},
+				&corev1.ConfigMap{}: {Label: controller.TaskPodSelector()},
+			},
+		},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -144,13 +169,49 @@ func main() {
 		os.Exit(1)
 	}
 
+	kubeClient, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create kubernetes client")
+		os.Exit(1)
+	}
+
 	if err = (&controller.WorkflowReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		ArtifactRepository: artifactRepository,
+		KubeClient:         kubeClient,
+		APIReader:          mgr.GetAPIReader(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Workflow")
+		os.Exit(1)
+	}
+	if err = (&controller.CronWorkflowReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Workflow")
+		setupLog.Error(err, "unable to create controller", "controller", "CronWorkflow")
 		os.Exit(1)
 	}
+	// nolint:goconst
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		workflowDefaults, err := webhooksky.LoadWorkflowDefaults(workflowDefaultsPath)
+		if err != nil {
+			setupLog.Error(err, "unable to load workflow defaults")
+			os.Exit(1)
+		}
+		if err = webhooksky.SetupWorkflowWebhookWithManager(mgr, workflowDefaults); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Workflow")
+			os.Exit(1)
+		}
+		if err = webhooksky.SetupWorkflowTemplateWebhookWithManager(mgr, workflowDefaults); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WorkflowTemplate")
+			os.Exit(1)
+		}
+		if err = webhooksky.SetupClusterWorkflowTemplateWebhookWithManager(mgr, workflowDefaults); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "ClusterWorkflowTemplate")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,251 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Environment variables the controller sets on the steps that transfer artifacts.
+const (
+	artifactEndpointEnv  = "SKY_ARTIFACT_ENDPOINT"
+	artifactBucketEnv    = "SKY_ARTIFACT_BUCKET"
+	artifactRegionEnv    = "SKY_ARTIFACT_REGION"
+	artifactInsecureEnv  = "SKY_ARTIFACT_INSECURE"
+	artifactAccessKeyEnv = "SKY_ARTIFACT_ACCESS_KEY"
+	artifactSecretKeyEnv = "SKY_ARTIFACT_SECRET_KEY"
+)
+
+type artifactStore struct {
+	client *minio.Client
+	bucket string
+}
+
+type artifactTransfer func(ctx context.Context, store *artifactStore, key, path string) error
+
+func newArtifactStore() (*artifactStore, error) {
+	bucket := os.Getenv(artifactBucketEnv)
+	if bucket == "" {
+		return nil, fmt.Errorf("%s is not set", artifactBucketEnv)
+	}
+	insecure, _ := strconv.ParseBool(os.Getenv(artifactInsecureEnv))
+
+	client, err := minio.New(os.Getenv(artifactEndpointEnv), &minio.Options{
+		Creds:  credentials.NewStaticV4(os.Getenv(artifactAccessKeyEnv), os.Getenv(artifactSecretKeyEnv), ""),
+		Secure: !insecure,
+		Region: os.Getenv(artifactRegionEnv),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &artifactStore{client: client, bucket: bucket}, nil
+}
+
+func (e *Exec) transferArtifacts(artifacts map[string]string, transfer artifactTransfer) error {
+	store, err := newArtifactStore()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for key, path := range artifacts {
+		if err := transfer(ctx, store, key, path); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+// uploadArtifact stores the content of a directory, or a single file, as a gzipped tarball.
+func uploadArtifact(ctx context.Context, store *artifactStore, key, path string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(archive(path, writer))
+	}()
+
+	_, err := store.client.PutObject(ctx, store.bucket, key, reader, -1, minio.PutObjectOptions{ContentType: "application/gzip"})
+	reader.Close()
+	return err
+}
+
+// downloadArtifact extracts an artifact into a directory.
+func downloadArtifact(ctx context.Context, store *artifactStore, key, path string) error {
+	object, err := store.client.GetObject(ctx, store.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return err
+	}
+	defer object.Close()
+	return extract(object, path)
+}
+
+func archive(root string, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if name == "." {
+			if info.IsDir() {
+				return nil
+			}
+			name = info.Name()
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// extract unpacks an artifact into a directory. Entries are only written below root: symlinks
+// must point inside it and no entry is written through a symlink, so a hostile archive cannot
+// reach files outside root.
+func extract(r io.Reader, root string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	root = filepath.Clean(root)
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(root, header.Name)
+		if !withinRoot(root, path) {
+			return fmt.Errorf("invalid file name %s in artifact", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirBelow(root, path, os.FileMode(header.Mode)|0700); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if filepath.IsAbs(header.Linkname) || !withinRoot(root, filepath.Join(filepath.Dir(path), header.Linkname)) {
+				return fmt.Errorf("symlink %s in artifact points outside of it: %s", header.Name, header.Linkname)
+			}
+			if err := mkdirBelow(root, filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, path); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := mkdirBelow(root, filepath.Dir(path), os.ModePerm); err != nil {
+				return err
+			}
+			if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				return fmt.Errorf("file %s in artifact would be written through a symlink", header.Name)
+			}
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// withinRoot reports whether the cleaned path is root or below it.
+func withinRoot(root, path string) bool {
+	return path == root || strings.HasPrefix(path, root+string(os.PathSeparator))
+}
+
+// mkdirBelow creates the directories from root down to dir, and fails when one of them is a
+// symlink or not a directory.
+func mkdirBelow(root, dir string, perm os.FileMode) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := root
+	for _, name := range strings.Split(rel, string(os.PathSeparator)) {
+		current = filepath.Join(current, name)
+		info, err := os.Lstat(current)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(current, perm); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			return fmt.Errorf("directory %s in artifact is a symlink", current)
+		case !info.IsDir():
+			return fmt.Errorf("%s in artifact is not a directory", current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// tarEntry is an entry of a hand-made archive.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func buildArchive(entries []tarEntry) *bytes.Buffer {
+	buffer := &bytes.Buffer{}
+	gz := gzip.NewWriter(buffer)
+	tw := tar.NewWriter(gz)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Typeflag: entry.typeflag, Linkname: entry.linkname, Mode: 0644, Size: int64(len(entry.content))}
+		if entry.typeflag == tar.TypeDir {
+			header.Mode = 0755
+		}
+		Expect(tw.WriteHeader(header)).To(Succeed())
+		if entry.typeflag == tar.TypeReg {
+			_, err := tw.Write([]byte(entry.content))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	Expect(tw.Close()).To(Succeed())
+	Expect(gz.Close()).To(Succeed())
+	return buffer
+}
+
+// readTree returns the files of a directory by relative path: the content of regular files with
+// their permissions, the target of symlinks and a marker for directories.
+func readTree(root string) map[string]string {
+	tree := map[string]string{}
+	Expect(filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil || name == "." {
+			return err
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			tree[name] = "-> " + target
+			return err
+		case info.IsDir():
+			tree[name] = "dir"
+		default:
+			content, err := os.ReadFile(path)
+			tree[name] = fmt.Sprintf("%s %s", info.Mode().Perm(), content)
+			return err
+		}
+		return nil
+	})).To(Succeed())
+	return tree
+}
+
+// writeTree creates files by relative path, with the notation of readTree.
+func writeTree(root string, tree map[string]string) {
+	for name, entry := range tree {
+		path := filepath.Join(root, name)
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		switch {
+		case entry == "dir":
+			Expect(os.MkdirAll(path, 0755)).To(Succeed())
+		case strings.HasPrefix(entry, "-> "):
+			Expect(os.Symlink(strings.TrimPrefix(entry, "-> "), path)).To(Succeed())
+		default:
+			mode, content, _ := strings.Cut(entry, " ")
+			perm := fs.FileMode(0644)
+			if mode == "-rwxr-xr-x" {
+				perm = 0755
+			}
+			Expect(os.WriteFile(path, []byte(content), perm)).To(Succeed())
+			Expect(os.Chmod(path, perm)).To(Succeed())
+		}
+	}
+}
+
+var _ = Describe("Artifacts", func() {
+	var dir, root, outside string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		root = filepath.Join(dir, "root")
+		outside = filepath.Join(dir, "outside")
+		Expect(os.MkdirAll(outside, 0755)).To(Succeed())
+	})
+
+	DescribeTable("should reject archives writing outside of the directory",
+		func(entries []tarEntry, message string) {
+			Expect(extract(buildArchive(entries), root)).To(MatchError(ContainSubstring(message)))
+			Expect(os.ReadDir(outside)).To(BeEmpty())
+		},
+		Entry("a parent path", []tarEntry{
+			{name: "../outside/passwd", typeflag: tar.TypeReg, content: "x"},
+		}, "invalid file name"),
+		Entry("an absolute symlink", []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "/etc"},
+		}, "points outside"),
+		Entry("a relative symlink leaving the directory", []tarEntry{
+			{name: "sub/a", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+		}, "points outside"),
+		Entry("a file written through a symlinked directory", []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "b", typeflag: tar.TypeSymlink, linkname: "a"},
+			{name: "b/passwd", typeflag: tar.TypeReg, content: "x"},
+		}, "is a symlink"),
+		Entry("a file replacing a symlink", []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "b"},
+			{name: "a", typeflag: tar.TypeReg, content: "x"},
+		}, "through a symlink"),
+	)
+
+	It("should not follow a symlink already in the directory", func() {
+		Expect(os.MkdirAll(root, 0755)).To(Succeed())
+		Expect(os.Symlink(outside, filepath.Join(root, "cache"))).To(Succeed())
+
+		err := extract(buildArchive([]tarEntry{{name: "cache/passwd", typeflag: tar.TypeReg, content: "x"}}), root)
+		Expect(err).To(MatchError(ContainSubstring("is a symlink")))
+		Expect(os.ReadDir(outside)).To(BeEmpty())
+	})
+
+	It("should extract symlinks pointing inside the directory", func() {
+		Expect(extract(buildArchive([]tarEntry{
+			{name: "bin", typeflag: tar.TypeDir},
+			{name: "bin/app", typeflag: tar.TypeReg, content: "binary"},
+			{name: "latest", typeflag: tar.TypeSymlink, linkname: "bin/app"},
+		}), root)).To(Succeed())
+		Expect(os.ReadFile(filepath.Join(root, "latest"))).To(Equal([]byte("binary")))
+	})
+
+	DescribeTable("should extract what was archived",
+		func(tree map[string]string) {
+			source := filepath.Join(dir, "source")
+			Expect(os.MkdirAll(source, 0755)).To(Succeed())
+			writeTree(source, tree)
+
+			buffer := &bytes.Buffer{}
+			Expect(archive(source, buffer)).To(Succeed())
+			Expect(extract(buffer, root)).To(Succeed())
+			Expect(readTree(root)).To(Equal(readTree(source)))
+		},
+		Entry("an empty directory", map[string]string{}),
+		Entry("files", map[string]string{"a.txt": "-rw-r--r-- hello", "empty": "-rw-r--r-- "}),
+		Entry("nested directories", map[string]string{"bin/app": "-rwxr-xr-x binary", "lib/x/y/z.so": "-rw-r--r-- lib", "tmp": "dir"}),
+		Entry("symlinks inside the directory", map[string]string{"bin/app": "-rwxr-xr-x binary", "app": "-> bin/app", "current": "-> bin"}),
+	)
+
+	It("should extract an archived file into the directory", func() {
+		file := filepath.Join(dir, "report.txt")
+		Expect(os.WriteFile(file, []byte("report"), 0644)).To(Succeed())
+
+		buffer := &bytes.Buffer{}
+		Expect(archive(file, buffer)).To(Succeed())
+		Expect(extract(buffer, root)).To(Succeed())
+		Expect(os.ReadFile(filepath.Join(root, "report.txt"))).To(Equal([]byte("report")))
+	})
+
+	// The transfers run against the bucket of a local MinIO, for instance:
+	//
+	//	docker run -d -p 9000:9000 minio/minio server /data
+	//	SKY_ARTIFACT_ENDPOINT=localhost:9000 SKY_ARTIFACT_INSECURE=true SKY_ARTIFACT_BUCKET=artifacts \
+	//	SKY_ARTIFACT_ACCESS_KEY=minioadmin SKY_ARTIFACT_SECRET_KEY=minioadmin go test ./cmd/entrypoint/
+	Context("with a MinIO bucket", func() {
+		BeforeEach(func() {
+			if os.Getenv(artifactEndpointEnv) == "" || os.Getenv(artifactBucketEnv) == "" {
+				Skip(fmt.Sprintf("%s and %s are not set", artifactEndpointEnv, artifactBucketEnv))
+			}
+			store, err := newArtifactStore()
+			Expect(err).NotTo(HaveOccurred())
+			exists, err := store.client.BucketExists(context.Background(), store.bucket)
+			Expect(err).NotTo(HaveOccurred())
+			if !exists {
+				Expect(store.client.MakeBucket(context.Background(), store.bucket, minio.MakeBucketOptions{})).To(Succeed())
+			}
+		})
+
+		It("should download the artifacts a step uploaded", func() {
+			key := fmt.Sprintf("entrypoint-test/%d/build.tgz", time.Now().UnixNano())
+			source := filepath.Join(dir, "build")
+			writeTree(source, map[string]string{"bin/app": "-rwxr-xr-x binary", "app": "-> bin/app"})
+
+			upload := &Exec{Command: "/bin/sh", Args: []string{"-c", "true"}, OutputArtifacts: map[string]string{key: source}}
+			Expect(upload.Run()).To(Succeed())
+			download := &Exec{Command: "/bin/sh", Args: []string{"-c", "true"}, InputArtifacts: map[string]string{key: root}}
+			Expect(download.Run()).To(Succeed())
+			Expect(readTree(root)).To(Equal(readTree(source)))
+
+			store, err := newArtifactStore()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.client.RemoveObject(context.Background(), store.bucket, key, minio.RemoveObjectOptions{})).To(Succeed())
+		})
+
+		It("should fail to download a missing artifact", func() {
+			download := &Exec{Command: "/bin/sh", Args: []string{"-c", "true"}, InputArtifacts: map[string]string{"entrypoint-test/missing.tgz": root}}
+			Expect(download.Run()).To(MatchError(ContainSubstring("failed to download artifacts")))
+		})
+	})
+})
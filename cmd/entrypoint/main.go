@@ -3,8 +3,10 @@ package main
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/spf13/cobra"
+	"io"
 	"log"
 	"os"
 	"os/exec"
@@ -14,15 +16,30 @@ import (
 )
 
 type Exec struct {
-	WaitFile         string
+	// WaitFiles are the post files of the previous stage, the step starts once all of them exist.
+	WaitFiles        []string
+	WaitContent      string
 	PostFile         string
+	PostContent      string
 	Outputs          []string
+	ResultsDir       string
 	Command          string
 	Args             []string
 	TerminationPath  string
 	EncodeScriptPath string
+	// InputArtifacts and OutputArtifacts map object keys to the directories they are extracted to
+	// and archived from.
+	InputArtifacts  map[string]string
+	OutputArtifacts map[string]string
+	// StopFiles are the post files of the other steps of the stage, the step is stopped as soon as
+	// one of them failed.
+	StopFiles []string
 }
 
+// errorFileSuffix marks the post file of a failed step, the steps waiting for it stop instead of
+// waiting forever.
+const errorFileSuffix = ".err"
+
 func (e *Exec) DecodeScript() error {
 	scriptFile, err := os.ReadFile(e.EncodeScriptPath)
 	if err != nil {
@@ -41,20 +58,38 @@ func (e *Exec) DecodeScript() error {
 }
 
 func (e *Exec) Wait() error {
-	if e.WaitFile != "" {
-		return fmt.Errorf("wait file not implemented")
+	for _, waitFile := range e.WaitFiles {
+		if err := e.waitFile(waitFile); err != nil {
+			return err
+		}
 	}
+	return nil
+}
+
+func (e *Exec) waitFile(waitFile string) error {
 	for {
-		_, err := os.Stat(e.WaitFile)
-		if err == nil {
+		if _, err := os.Stat(waitFile + errorFileSuffix); err == nil {
+			return fmt.Errorf("previous step failed")
+		}
+		content, err := os.ReadFile(waitFile)
+		if err == nil && (e.WaitContent == "" || strings.TrimSpace(string(content)) == e.WaitContent) {
 			return nil
 		}
-		if os.IsNotExist(err) {
-			time.Sleep(10 * time.Millisecond)
-			continue
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// failedStopFile returns the post file of the first failed step of the stage.
+func (e *Exec) failedStopFile() string {
+	for _, stopFile := range e.StopFiles {
+		if _, err := os.Stat(stopFile + errorFileSuffix); err == nil {
+			return stopFile
 		}
-		return err
 	}
+	return ""
 }
 
 func (e *Exec) CreatePostFile() error {
@@ -64,17 +99,35 @@ func (e *Exec) CreatePostFile() error {
 	if err := os.MkdirAll(filepath.Dir(e.PostFile), os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create post file directory %s: %v", e.PostFile, err)
 	}
-	f, err := os.OpenFile(e.PostFile, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
+	// The content is written to a temporary file first so that waiting steps never read it partially.
+	if err := os.WriteFile(e.PostFile+".tmp", []byte(e.PostContent), 0666); err != nil {
 		return fmt.Errorf("failed to create post file %s: %v", e.PostFile, err)
 	}
-	defer f.Close()
-	return nil
+	return os.Rename(e.PostFile+".tmp", e.PostFile)
+}
+
+// CreateErrorFile tells the steps waiting for this one that it failed.
+func (e *Exec) CreateErrorFile() error {
+	if e.PostFile == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(e.PostFile), os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create post file directory %s: %v", e.PostFile, err)
+	}
+	return os.WriteFile(e.PostFile+errorFileSuffix, nil, 0666)
 }
 
+// maxTerminationMessageSize is the size the kubelet truncates termination messages to.
+const maxTerminationMessageSize = 1024 * 4
+
+// offloadedOutputPrefix starts the log lines carrying the outputs that do not fit into the
+// termination message, the controller reads them back from the logs of the step.
+const offloadedOutputPrefix = "::sky-output::"
+
 type output struct {
-	Name  string `json:"name"`
-	Value string `json:"value"`
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Offloaded bool   `json:"offloaded,omitempty"`
 }
 
 func (e *Exec) readResultsFromDisk() error {
@@ -83,7 +136,7 @@ func (e *Exec) readResultsFromDisk() error {
 		if result == "" {
 			continue
 		}
-		file, err := os.ReadFile(filepath.Join("/tmp/results", result))
+		file, err := os.ReadFile(filepath.Join(e.ResultsDir, result))
 		if os.IsNotExist(err) {
 			continue
 		} else if err != nil {
@@ -113,15 +166,12 @@ func (e *Exec) writeTerminationMessage(path string, outputs []output) error {
 	} else if !os.IsNotExist(err) {
 		return err
 	}
-	jsonOutput, err := json.Marshal(outputs)
+	jsonOutput, err := offloadOutputs(os.Stdout, outputs)
 	if err != nil {
 		return err
 	}
-	if len(jsonOutput) > 1024*4 {
-		return fmt.Errorf("termination message too large")
-	}
 
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0666)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
@@ -133,11 +183,77 @@ func (e *Exec) writeTerminationMessage(path string, outputs []output) error {
 	return f.Sync()
 }
 
+// offloadOutputs moves the largest outputs to the logs until the others fit into the termination
+// message, and returns the termination message.
+func offloadOutputs(w io.Writer, outputs []output) ([]byte, error) {
+	for {
+		jsonOutput, err := json.Marshal(outputs)
+		if err != nil {
+			return nil, err
+		}
+		if len(jsonOutput) <= maxTerminationMessageSize {
+			return jsonOutput, nil
+		}
+
+		largest := -1
+		for i, o := range outputs {
+			if !o.Offloaded && (largest == -1 || len(o.Value) > len(outputs[largest].Value)) {
+				largest = i
+			}
+		}
+		if largest == -1 {
+			return nil, fmt.Errorf("termination message too large")
+		}
+		encoded := base64.StdEncoding.EncodeToString([]byte(outputs[largest].Value))
+		if _, err := fmt.Fprintf(w, "\n%s%s::%s\n", offloadedOutputPrefix, outputs[largest].Name, encoded); err != nil {
+			return nil, err
+		}
+		outputs[largest].Value = ""
+		outputs[largest].Offloaded = true
+	}
+}
+
 func (e *Exec) Run() error {
+	if len(e.InputArtifacts) != 0 {
+		if err := e.transferArtifacts(e.InputArtifacts, downloadArtifact); err != nil {
+			return fmt.Errorf("failed to download artifacts: %v", err)
+		}
+	}
+
 	cmd := exec.Command(e.Command, e.Args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	stopped := make(chan string, 1)
+	done := make(chan struct{})
+	if len(e.StopFiles) != 0 {
+		go func() {
+			ticker := time.NewTicker(100 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if stopFile := e.failedStopFile(); stopFile != "" {
+						stopped <- stopFile
+						_ = cmd.Process.Kill()
+						return
+					}
+				}
+			}
+		}()
+	}
+	err := cmd.Wait()
+	close(done)
+	select {
+	case stopFile := <-stopped:
+		return fmt.Errorf("stopped, step %s of the stage failed", filepath.Base(stopFile))
+	default:
+	}
+	if err != nil {
 		return err
 	}
 
@@ -147,17 +263,61 @@ func (e *Exec) Run() error {
 		}
 	}
 
+	if len(e.OutputArtifacts) != 0 {
+		if err := e.transferArtifacts(e.OutputArtifacts, uploadArtifact); err != nil {
+			return fmt.Errorf("failed to upload artifacts: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// fail stops the steps waiting for this one and exits with the exit code of the step, so that
+// retry strategies can filter on it.
+func (e *Exec) fail(err error) {
+	if _err := e.CreateErrorFile(); _err != nil {
+		log.Println(_err)
+	}
+	log.Println(err)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	os.Exit(1)
+}
+
+// splitParams splits the args of a step on commas into the arguments of its script.
+func splitParams(params string) []string {
+	return strings.Split(params, ",")
+}
+
+// parseArtifacts parses `<key>=<path>` pairs.
+func parseArtifacts(pairs []string) (map[string]string, error) {
+	artifacts := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, path, ok := strings.Cut(pair, "=")
+		if !ok || key == "" || path == "" {
+			return nil, fmt.Errorf("invalid artifact %q, expected <key>=<path>", pair)
+		}
+		artifacts[key] = path
+	}
+	return artifacts, nil
+}
+
 func main() {
 	var encodeScriptPath string
-	var waitFile string
+	var waitFiles []string
+	var stopFiles []string
+	var waitContent string
 	var postFile string
+	var postContent string
 	var command string
 	var params string
 	var terminationPath string
 	var outputs string
+	var resultsDir string
+	var inputArtifacts []string
+	var outputArtifacts []string
 
 	cmd := &cobra.Command{
 		Use:   "",
@@ -165,35 +325,60 @@ func main() {
 		Long:  "",
 		Run: func(cmd *cobra.Command, args []string) {
 			e := Exec{
-				WaitFile:         waitFile,
+				WaitFiles:        waitFiles,
+				StopFiles:        stopFiles,
+				WaitContent:      waitContent,
 				PostFile:         postFile,
-				Outputs:          strings.Split(outputs, ","),
+				PostContent:      postContent,
+				Outputs:          strings.Fields(outputs),
+				ResultsDir:       resultsDir,
 				Command:          command,
-				Args:             strings.Split(params, ","),
+				Args:             splitParams(params),
 				TerminationPath:  terminationPath,
 				EncodeScriptPath: encodeScriptPath,
 			}
-			if err := e.DecodeScript(); err != nil {
-				log.Fatalln(err)
+			// The init container only decodes the scripts of the steps.
+			if e.EncodeScriptPath != "" {
+				if err := e.DecodeScript(); err != nil {
+					log.Fatalln(err)
+				}
+				return
+			}
+
+			var err error
+			if e.InputArtifacts, err = parseArtifacts(inputArtifacts); err != nil {
+				e.fail(err)
+			}
+			if e.OutputArtifacts, err = parseArtifacts(outputArtifacts); err != nil {
+				e.fail(err)
 			}
 			if err := e.Wait(); err != nil {
-				log.Fatalln(err)
+				e.fail(err)
 			}
 			if err := e.Run(); err != nil {
-				log.Fatalln(err)
+				e.fail(err)
 			}
 			if err := e.CreatePostFile(); err != nil {
-				log.Fatalln(err)
+				e.fail(err)
 			}
 		},
 	}
-	cmd.Flags().StringVarP(&encodeScriptPath, "encode_script", "", "", "")
-	cmd.Flags().StringVarP(&waitFile, "wait_file", "", "", "")
-	cmd.Flags().StringVarP(&postFile, "post_file", "", "", "")
-	cmd.Flags().StringVarP(&command, "command", "", "/bin/sh", "")
-	cmd.Flags().StringVarP(&outputs, "outputs", "", "", "")
-	cmd.Flags().StringVarP(&terminationPath, "termination_path", "", "/tmp/termination-log", "")
-	cmd.Flags().StringVarP(&params, "params", "", "", "")
+	// The controller starts the init container with --encode_script, and every step with the
+	// files of the previous stage to wait for, its own post file and the files of its stage.
+	cmd.Flags().StringVarP(&encodeScriptPath, "encode_script", "", "", "base64-encoded script to decode in place, the init container only decodes the scripts")
+	cmd.Flags().StringArrayVarP(&waitFiles, "wait_file", "", nil, "post file of a step of the previous stage, repeated for every step")
+	cmd.Flags().StringVarP(&waitContent, "wait_content", "", "", "content the wait files must hold, any content when empty")
+	cmd.Flags().StringVarP(&postFile, "post_file", "", "", "file written once the step succeeded, <post_file>.err once it failed")
+	cmd.Flags().StringVarP(&postContent, "post_content", "", "", "content of the post file")
+	cmd.Flags().StringVarP(&command, "command", "", "/bin/sh", "script of the step")
+	cmd.Flags().StringVarP(&outputs, "results", "", "", "space-separated names of the results")
+	cmd.Flags().StringVarP(&resultsDir, "results_dir", "", "/tmp/sky/outputs", "directory the step writes its results to, one file per result")
+	cmd.Flags().StringVarP(&terminationPath, "termination_message_path", "", "/tmp/termination-log", "file the results are written to as the termination message of the container")
+	cmd.Flags().StringVarP(&params, "params", "", "", "comma-separated arguments of the script")
+	cmd.Flags().Bool("encode", true, "scripts are base64-encoded, kept for compatibility")
+	cmd.Flags().StringArrayVarP(&stopFiles, "stop_file", "", nil, "post file of another step of the stage, the step stops when it failed")
+	cmd.Flags().StringArrayVarP(&inputArtifacts, "input_artifact", "", nil, "<key>=<path> of an artifact to download before the step")
+	cmd.Flags().StringArrayVarP(&outputArtifacts, "output_artifact", "", nil, "<key>=<path> of an artifact to upload after the step")
 	if err := cmd.Execute(); err != nil {
 		log.Fatalln(err)
 	}
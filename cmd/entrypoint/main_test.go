@@ -0,0 +1,143 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Entrypoint", func() {
+	DescribeTable("should split the args of a step on commas",
+		func(params string, expected []string) {
+			Expect(splitParams(params)).To(Equal(expected))
+		},
+		Entry("comma-separated args", "{{inputs.input-1}},arg-2,参数3", []string{"{{inputs.input-1}}", "arg-2", "参数3"}),
+		Entry("spaces within an argument", "a b,c", []string{"a b", "c"}),
+	)
+
+	DescribeTable("should offload the largest outputs until the termination message fits",
+		func(sizes []int, offloaded []string) {
+			var outputs []output
+			for i, size := range sizes {
+				outputs = append(outputs, output{Name: string(rune('a' + i)), Value: strings.Repeat("x", size)})
+			}
+			logs := &bytes.Buffer{}
+			message, err := offloadOutputs(logs, outputs)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(len(message)).To(BeNumerically("<=", maxTerminationMessageSize))
+
+			var decoded []output
+			Expect(json.Unmarshal(message, &decoded)).To(Succeed())
+			var names []string
+			for i, o := range decoded {
+				if o.Offloaded {
+					names = append(names, o.Name)
+					Expect(o.Value).To(BeEmpty())
+					encoded := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", sizes[i])))
+					Expect(logs.String()).To(ContainSubstring(offloadedOutputPrefix + o.Name + "::" + encoded + "\n"))
+				} else {
+					Expect(o.Value).To(HaveLen(sizes[i]))
+				}
+			}
+			Expect(names).To(Equal(offloaded))
+		},
+		Entry("small outputs", []int{10, 20}, nil),
+		Entry("one large output", []int{10, 5000, 20}, []string{"b"}),
+		Entry("several large outputs", []int{3000, 100, 3500, 2000}, []string{"a", "c"}),
+	)
+
+	It("should write the results to the termination message", func() {
+		dir := GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "version"), []byte("1.2.3"), 0644)).To(Succeed())
+		e := &Exec{Outputs: []string{"version", "missing"}, ResultsDir: dir, TerminationPath: filepath.Join(dir, "termination-log")}
+		Expect(e.readResultsFromDisk()).To(Succeed())
+		Expect(os.ReadFile(e.TerminationPath)).To(MatchJSON(`[{"name":"version","value":"1.2.3"}]`))
+	})
+
+	Context("with the steps of a Pod", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir = GinkgoT().TempDir()
+		})
+
+		It("should start once the previous stage posted its files", func() {
+			first := &Exec{PostFile: filepath.Join(dir, "0"), PostContent: "0"}
+			second := &Exec{PostFile: filepath.Join(dir, "1"), PostContent: "1"}
+			next := &Exec{WaitFiles: []string{first.PostFile, second.PostFile}}
+
+			done := make(chan error)
+			go func() {
+				done <- next.Wait()
+			}()
+			Expect(first.CreatePostFile()).To(Succeed())
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(second.CreatePostFile()).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should wait for the expected content", func() {
+			Expect(os.WriteFile(filepath.Join(dir, "0"), []byte("1\n"), 0644)).To(Succeed())
+			e := &Exec{WaitFiles: []string{filepath.Join(dir, "0")}, WaitContent: "0"}
+
+			done := make(chan error)
+			go func() {
+				done <- e.Wait()
+			}()
+			Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+			Expect(os.WriteFile(filepath.Join(dir, "0"), []byte("0\n"), 0644)).To(Succeed())
+			Eventually(done).Should(Receive(BeNil()))
+		})
+
+		It("should not start after a failed step of the previous stage", func() {
+			previous := &Exec{PostFile: filepath.Join(dir, "0")}
+			Expect(previous.CreateErrorFile()).To(Succeed())
+
+			e := &Exec{WaitFiles: []string{previous.PostFile}}
+			Expect(e.Wait()).To(MatchError("previous step failed"))
+		})
+
+		It("should stop when another step of the stage failed", func() {
+			sibling := &Exec{PostFile: filepath.Join(dir, "1")}
+			e := &Exec{Command: "/bin/sh", Args: []string{"-c", "exec sleep 30"}, StopFiles: []string{sibling.PostFile}}
+
+			done := make(chan error)
+			go func() {
+				done <- e.Run()
+			}()
+			Consistently(done, 200*time.Millisecond).ShouldNot(Receive())
+			Expect(sibling.CreateErrorFile()).To(Succeed())
+			Eventually(done, 5*time.Second).Should(Receive(MatchError("stopped, step 1 of the stage failed")))
+		})
+
+		It("should keep running while the other steps of the stage succeed", func() {
+			sibling := &Exec{PostFile: filepath.Join(dir, "1"), PostContent: "1"}
+			Expect(sibling.CreatePostFile()).To(Succeed())
+			e := &Exec{Command: "/bin/sh", Args: []string{"-c", "sleep 0.3"}, StopFiles: []string{sibling.PostFile}}
+			Expect(e.Run()).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,172 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+// isCompleted reports whether the workflow reached a status it never leaves.
+func isCompleted(workflow *skyv1alpha1.Workflow) bool {
+	switch workflow.Status.Status {
+	case skyv1alpha1.WorkFlowStatusSuccess, skyv1alpha1.WorkFlowStatusFailed, skyv1alpha1.WorkFlowStatusCancel:
+		return true
+	}
+	return false
+}
+
+func getWorkflow(ctx context.Context, o *options, name string) (*skyv1alpha1.Workflow, error) {
+	workflow := &skyv1alpha1.Workflow{}
+	if err := o.client.Get(ctx, types.NamespacedName{Namespace: o.namespace, Name: name}, workflow); err != nil {
+		return nil, fmt.Errorf("failed to get workflow %s: %v", name, err)
+	}
+	return workflow, nil
+}
+
+// patchWorkflows applies the change to the spec of every named workflow still running.
+func patchWorkflows(cmd *cobra.Command, o *options, names []string, verb string, change func(spec *skyv1alpha1.WorkflowSpec)) error {
+	ctx := cmd.Context()
+	for _, name := range names {
+		workflow, err := getWorkflow(ctx, o, name)
+		if err != nil {
+			return err
+		}
+		if isCompleted(workflow) {
+			return fmt.Errorf("workflow %s already completed with status %s", name, workflow.Status.Status)
+		}
+
+		patch := client.MergeFrom(workflow.DeepCopy())
+		change(&workflow.Spec)
+		if err := o.client.Patch(ctx, workflow, patch); err != nil {
+			return fmt.Errorf("failed to patch workflow %s: %v", name, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "workflow %s %s\n", name, verb)
+	}
+	return nil
+}
+
+func newCancelCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "cancel WORKFLOW...",
+		Short: "Stop the running tasks of workflows and skip the others, finally tasks still run",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return patchWorkflows(cmd, o, args, "cancelled", func(spec *skyv1alpha1.WorkflowSpec) {
+				spec.Cancel = true
+			})
+		},
+	}
+}
+
+func newSuspendCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "suspend WORKFLOW...",
+		Short: "Stop scheduling the tasks of workflows, running tasks are left to finish",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return patchWorkflows(cmd, o, args, "suspended", func(spec *skyv1alpha1.WorkflowSpec) {
+				spec.Suspend = true
+			})
+		},
+	}
+}
+
+func newResumeCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume WORKFLOW...",
+		Short: "Resume suspended workflows",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return patchWorkflows(cmd, o, args, "resumed", func(spec *skyv1alpha1.WorkflowSpec) {
+				spec.Suspend = false
+			})
+		},
+	}
+}
+
+func newRetryCommand(o *options) *cobra.Command {
+	var params []string
+	var watch bool
+
+	cmd := &cobra.Command{
+		Use:   "retry WORKFLOW",
+		Short: "Submit a completed workflow again as a new workflow",
+		Long: `Submit a completed workflow again as a new workflow named after it. Every task runs again,
+templates are resolved again for workflows referencing them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			previous, err := getWorkflow(ctx, o, args[0])
+			if err != nil {
+				return err
+			}
+			if !isCompleted(previous) {
+				return fmt.Errorf("workflow %s is still running", previous.Name)
+			}
+
+			workflow := &skyv1alpha1.Workflow{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: previous.Name + "-",
+					Namespace:    previous.Namespace,
+				},
+				Spec: *previous.Spec.DeepCopy(),
+			}
+			workflow.Spec.Cancel = false
+			workflow.Spec.Suspend = false
+			if err := setInputs(workflow, params); err != nil {
+				return err
+			}
+			if err := o.client.Create(ctx, workflow); err != nil {
+				return fmt.Errorf("failed to submit the workflow: %v", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "workflow %s submitted as a retry of %s\n", workflow.Name, previous.Name)
+			if watch {
+				return watchWorkflow(ctx, o, workflow.Name, cmd.OutOrStdout())
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringArrayVarP(&params, "parameter", "p", nil, "input of the workflow as name=value, repeated for every input")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "watch the workflow until it completed")
+	return cmd
+}
+
+func newDeleteCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete WORKFLOW...",
+		Short: "Delete workflows with their Pods and child workflows",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				workflow := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: o.namespace}}
+				if err := o.client.Delete(cmd.Context(), workflow, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil {
+					return fmt.Errorf("failed to delete workflow %s: %v", name, err)
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "workflow %s deleted\n", name)
+			}
+			return nil
+		},
+	}
+}
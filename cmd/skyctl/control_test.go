@@ -0,0 +1,83 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Control", func() {
+	var out *bytes.Buffer
+	var cmd *cobra.Command
+
+	BeforeEach(func() {
+		out = &bytes.Buffer{}
+		cmd = &cobra.Command{}
+		cmd.SetContext(context.Background())
+		cmd.SetOut(out)
+	})
+
+	workflow := func(name string, status skyv1alpha1.WorkStatus) *skyv1alpha1.Workflow {
+		return &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Status:     skyv1alpha1.WorkflowStatus{Status: status},
+		}
+	}
+	suspend := func(spec *skyv1alpha1.WorkflowSpec) {
+		spec.Suspend = true
+	}
+
+	It("should patch the spec of running workflows", func() {
+		o := newFakeOptions(workflow("build", skyv1alpha1.WorkFlowStatusRunning), workflow("test", ""))
+		Expect(patchWorkflows(cmd, o, []string{"build", "test"}, "suspended", suspend)).To(Succeed())
+		Expect(out.String()).To(Equal("workflow build suspended\nworkflow test suspended\n"))
+
+		for _, name := range []string{"build", "test"} {
+			patched := &skyv1alpha1.Workflow{}
+			Expect(o.client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, patched)).To(Succeed())
+			Expect(patched.Spec.Suspend).To(BeTrue())
+		}
+	})
+
+	It("should refuse completed workflows", func() {
+		o := newFakeOptions(workflow("build", skyv1alpha1.WorkFlowStatusRunning), workflow("deploy", skyv1alpha1.WorkFlowStatusSuccess))
+		err := patchWorkflows(cmd, o, []string{"deploy", "build"}, "suspended", suspend)
+		Expect(err).To(MatchError("workflow deploy already completed with status Success"))
+		Expect(out.String()).To(BeEmpty())
+
+		patched := &skyv1alpha1.Workflow{}
+		Expect(o.client.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: "deploy"}, patched)).To(Succeed())
+		Expect(patched.Spec.Suspend).To(BeFalse())
+	})
+
+	It("should report unknown workflows", func() {
+		err := patchWorkflows(cmd, newFakeOptions(), []string{"build"}, "cancelled", func(spec *skyv1alpha1.WorkflowSpec) {
+			spec.Cancel = true
+		})
+		Expect(err).To(MatchError(ContainSubstring("failed to get workflow build")))
+	})
+})
@@ -0,0 +1,235 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+func newListCommand(o *options) *cobra.Command {
+	var selector string
+	var allNamespaces bool
+
+	cmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List workflows, the most recent first",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listOptions := []client.ListOption{}
+			if !allNamespaces {
+				listOptions = append(listOptions, client.InNamespace(o.namespace))
+			}
+			if selector != "" {
+				labelSelector, err := labels.Parse(selector)
+				if err != nil {
+					return fmt.Errorf("invalid selector %q: %v", selector, err)
+				}
+				listOptions = append(listOptions, client.MatchingLabelsSelector{Selector: labelSelector})
+			}
+			workflows := &skyv1alpha1.WorkflowList{}
+			if err := o.client.List(cmd.Context(), workflows, listOptions...); err != nil {
+				return fmt.Errorf("failed to list workflows: %v", err)
+			}
+			sort.SliceStable(workflows.Items, func(i, j int) bool {
+				return workflows.Items[j].CreationTimestamp.Before(&workflows.Items[i].CreationTimestamp)
+			})
+			printWorkflows(cmd.OutOrStdout(), workflows.Items, allNamespaces)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&selector, "selector", "l", "", "label selector of the workflows")
+	cmd.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list the workflows of every namespace")
+	return cmd
+}
+
+func newGetCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "get WORKFLOW",
+		Short: "Show a workflow with the tree of its tasks and steps",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			workflow, err := getWorkflow(cmd.Context(), o, args[0])
+			if err != nil {
+				return err
+			}
+			printWorkflow(cmd.OutOrStdout(), workflow)
+			return nil
+		},
+	}
+}
+
+func printWorkflows(out io.Writer, workflows []skyv1alpha1.Workflow, allNamespaces bool) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	defer w.Flush()
+	if allNamespaces {
+		fmt.Fprint(w, "NAMESPACE\t")
+	}
+	fmt.Fprintln(w, "NAME\tSTATUS\tPROGRESS\tSTARTED\tDURATION\tMESSAGE")
+	for _, workflow := range workflows {
+		if allNamespaces {
+			fmt.Fprintf(w, "%s\t", workflow.Namespace)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", workflow.Name, orDash(string(workflow.Status.Status)), orDash(workflow.Status.Progress),
+			age(workflow.Status.StartTime), elapsed(workflow.Status.StartTime, workflow.Status.CompletionTime), workflow.Status.Message)
+	}
+}
+
+// printWorkflow prints the summary of a workflow followed by its tasks, with their instances and
+// steps, in declaration order.
+func printWorkflow(out io.Writer, workflow *skyv1alpha1.Workflow) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintf(w, "Name:\t%s\n", workflow.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", workflow.Namespace)
+	if workflow.Spec.WorkflowTemplateRef != nil {
+		fmt.Fprintf(w, "Template:\t%s/%s\n", workflow.Spec.WorkflowTemplateRef.GetKind(), workflow.Spec.WorkflowTemplateRef.Name)
+	}
+	fmt.Fprintf(w, "Status:\t%s\n", orDash(string(workflow.Status.Status)))
+	if workflow.Status.Message != "" {
+		fmt.Fprintf(w, "Message:\t%s\n", workflow.Status.Message)
+	}
+	if workflow.Status.StartTime != nil {
+		fmt.Fprintf(w, "Started:\t%s (%s ago)\n", workflow.Status.StartTime.Format(time.RFC3339), age(workflow.Status.StartTime))
+		fmt.Fprintf(w, "Duration:\t%s\n", elapsed(workflow.Status.StartTime, workflow.Status.CompletionTime))
+	}
+	if workflow.Status.Progress != "" {
+		fmt.Fprintf(w, "Progress:\t%s\n", workflow.Status.Progress)
+	}
+	for _, input := range workflow.Spec.Inputs {
+		fmt.Fprintf(w, "Input:\t%s=%s\n", input.Name, input.Value)
+	}
+	for _, output := range workflow.Status.Outputs {
+		fmt.Fprintf(w, "Output:\t%s=%s\n", output.Name, output.Value)
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "TASK\tSTATUS\tDURATION\tMESSAGE")
+	fmt.Fprintf(w, "%s\t%s\t%s\t\n", workflow.Name, orDash(string(workflow.Status.Status)), elapsed(workflow.Status.StartTime, workflow.Status.CompletionTime))
+	nodes := taskNodes(workflow.GetTasks(), workflow.Status.TaskStatus)
+	if finally := workflow.GetFinally(); len(finally) != 0 {
+		nodes = append(nodes, node{name: "finally", children: taskNodes(finally, workflow.Status.TaskStatus)})
+	}
+	printTree(w, nodes, "")
+}
+
+// node is a line of the task tree: a task, an instance of a fanned-out task or a step.
+type node struct {
+	name     string
+	status   string
+	duration string
+	message  string
+	children []node
+}
+
+func taskNodes(tasks []skyv1alpha1.Task, taskStatus map[string]skyv1alpha1.TaskStatus) []node {
+	nodes := make([]node, 0, len(tasks))
+	for _, task := range tasks {
+		status, ok := taskStatus[task.Name]
+		if !ok {
+			// The task waits for its dependencies.
+			nodes = append(nodes, node{name: task.Name, status: "-", duration: "-"})
+			continue
+		}
+		taskNode := statusNode(status)
+		for _, instance := range status.Instances {
+			taskNode.children = append(taskNode.children, statusNode(taskStatus[instance]))
+		}
+		nodes = append(nodes, taskNode)
+	}
+	return nodes
+}
+
+func statusNode(status skyv1alpha1.TaskStatus) node {
+	message := status.Message
+	switch {
+	case status.WorkflowName != "":
+		message = strings.TrimSpace(fmt.Sprintf("workflow %s %s", status.WorkflowName, message))
+	case len(status.Attempts) != 0:
+		message = strings.TrimSpace(fmt.Sprintf("attempt %d %s", len(status.Attempts)+1, message))
+	}
+	taskNode := node{
+		name:     status.Name,
+		status:   orDash(string(status.Status)),
+		duration: elapsed(status.StartTime, status.CompletionTime),
+		message:  message,
+	}
+	for _, step := range status.Steps {
+		stepMessage := step.Reason
+		if step.ExitCode != nil {
+			stepMessage = strings.TrimSpace(fmt.Sprintf("%s exit code %d", step.Reason, *step.ExitCode))
+		}
+		taskNode.children = append(taskNode.children, node{
+			name:     step.Name,
+			status:   orDash(string(step.State)),
+			duration: elapsed(step.StartTime, step.CompletionTime),
+			message:  stepMessage,
+		})
+	}
+	return taskNode
+}
+
+func printTree(w io.Writer, nodes []node, prefix string) {
+	for i, n := range nodes {
+		branch, indent := "├─ ", "│  "
+		if i == len(nodes)-1 {
+			branch, indent = "└─ ", "   "
+		}
+		fmt.Fprintf(w, "%s%s%s\t%s\t%s\t%s\n", prefix, branch, n.name, n.status, n.duration, n.message)
+		printTree(w, n.children, prefix+indent)
+	}
+}
+
+// elapsed returns how long something ran, until now while it runs.
+func elapsed(start, completion *metav1.Time) string {
+	if start == nil {
+		return "-"
+	}
+	end := time.Now()
+	if completion != nil {
+		end = completion.Time
+	}
+	return duration.HumanDuration(end.Sub(start.Time))
+}
+
+func age(t *metav1.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return duration.HumanDuration(time.Since(t.Time))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Get", func() {
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	at := func(seconds int) *metav1.Time {
+		t := metav1.NewTime(start.Add(time.Duration(seconds) * time.Second))
+		return &t
+	}
+	exitCode := func(code int32) *int32 {
+		return &code
+	}
+
+	It("should print the tree of tasks, instances and steps", func() {
+		workflow := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "build-x7k2p", Namespace: "default"},
+			Spec: skyv1alpha1.WorkflowSpec{
+				Inputs: []skyv1alpha1.Input{{Name: "revision", Value: "main"}},
+				Tasks: []skyv1alpha1.Task{
+					{Name: "compile"},
+					{Name: "test", WithItems: []string{"unit", "e2e"}},
+					{Name: "deploy"},
+				},
+				Finally: []skyv1alpha1.Task{{Name: "report"}},
+			},
+			Status: skyv1alpha1.WorkflowStatus{
+				Status:   skyv1alpha1.WorkFlowStatusRunning,
+				Progress: "3/6",
+				TaskStatus: map[string]skyv1alpha1.TaskStatus{
+					"compile": {
+						Name: "compile", Status: skyv1alpha1.TaskStatusSucceeded, StartTime: at(0), CompletionTime: at(90),
+						Steps: []skyv1alpha1.StepStatus{
+							{Name: "fetch", State: skyv1alpha1.StepStateTerminated, Reason: "Completed", ExitCode: exitCode(0), StartTime: at(5), CompletionTime: at(20)},
+							{Name: "make", State: skyv1alpha1.StepStateTerminated, Reason: "Completed", ExitCode: exitCode(0), StartTime: at(20), CompletionTime: at(90)},
+						},
+					},
+					"test": {Name: "test", Status: skyv1alpha1.TaskStatusRunning, Instances: []string{"test-0", "test-1"}},
+					"test-0": {
+						Name: "test-0", Parent: "test", Status: skyv1alpha1.TaskStatusFailed, StartTime: at(90), CompletionTime: at(100),
+						Message: "step run failed",
+						Steps: []skyv1alpha1.StepStatus{
+							{Name: "run", State: skyv1alpha1.StepStateTerminated, Reason: "Error", ExitCode: exitCode(2), StartTime: at(91), CompletionTime: at(100)},
+						},
+					},
+					"test-1": {
+						Name: "test-1", Parent: "test", Status: skyv1alpha1.TaskStatusRetrying,
+						Attempts: []skyv1alpha1.TaskAttempt{{PodName: "build-x7k2p-test-1-abcde"}},
+						Steps: []skyv1alpha1.StepStatus{
+							{Name: "run", State: skyv1alpha1.StepStateWaiting, Reason: "ContainerCreating"},
+						},
+					},
+				},
+			},
+		}
+
+		out := &bytes.Buffer{}
+		printWorkflow(out, workflow)
+		// The columns of the tasks without message end with padding.
+		lines := strings.Split(out.String(), "\n")
+		for i := range lines {
+			lines[i] = strings.TrimRight(lines[i], " ")
+		}
+		Expect(strings.Join(lines, "\n")).To(Equal(`Name:        build-x7k2p
+Namespace:   default
+Status:      Running
+Progress:    3/6
+Input:       revision=main
+
+TASK           STATUS       DURATION   MESSAGE
+build-x7k2p    Running      -
+├─ compile     Succeeded    90s
+│  ├─ fetch    Terminated   15s        Completed exit code 0
+│  └─ make     Terminated   70s        Completed exit code 0
+├─ test        Running      -
+│  ├─ test-0   Failed       10s        step run failed
+│  │  └─ run   Terminated   9s         Error exit code 2
+│  └─ test-1   Retrying     -          attempt 2
+│     └─ run   Waiting      -          ContainerCreating
+├─ deploy      -            -
+└─ finally
+   └─ report   -            -
+`))
+	})
+})
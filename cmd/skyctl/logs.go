@@ -0,0 +1,199 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+// logSource is a step container whose logs are printed.
+type logSource struct {
+	task    string
+	pod     string
+	step    string
+	prefix  string
+	waiting bool
+}
+
+func newLogsCommand(o *options) *cobra.Command {
+	var step string
+	var follow bool
+
+	cmd := &cobra.Command{
+		Use:   "logs WORKFLOW [TASK]",
+		Short: "Print the logs of the steps of a workflow, of a task or of a single step",
+		Long: `Print the logs of the steps of a workflow, or of a task with its instances when it is fanned
+out. Lines are prefixed with their task and step when several steps are printed. Child workflows
+run by tasks have their own logs.`,
+		Example: `  skyctl logs build-x7k2p
+  skyctl logs build-x7k2p test -s unit -f`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+			workflow, err := getWorkflow(ctx, o, args[0])
+			if err != nil {
+				return err
+			}
+			task := ""
+			if len(args) == 2 {
+				task = args[1]
+			}
+			sources, err := logSources(workflow, task, step)
+			if err != nil {
+				return err
+			}
+			return printLogs(ctx, o, sources, follow, cmd.OutOrStdout(), cmd.ErrOrStderr())
+		},
+	}
+	cmd.Flags().StringVarP(&step, "step", "s", "", "only print the logs of this step")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "stream the logs until the steps terminated")
+	return cmd
+}
+
+// logSources returns the step containers of the task, or of every task started so far in start
+// order. A fanned-out task is replaced by its instances.
+func logSources(workflow *skyv1alpha1.Workflow, task, step string) ([]logSource, error) {
+	var statuses []skyv1alpha1.TaskStatus
+	if task != "" {
+		status, ok := workflow.Status.TaskStatus[task]
+		if !ok {
+			return nil, fmt.Errorf("task %s of workflow %s has not started", task, workflow.Name)
+		}
+		if status.WorkflowName != "" {
+			return nil, fmt.Errorf("task %s runs workflow %s, print its logs instead", task, status.WorkflowName)
+		}
+		statuses = append(statuses, status)
+		for _, instance := range status.Instances {
+			statuses = append(statuses, workflow.Status.TaskStatus[instance])
+		}
+	} else {
+		for _, status := range workflow.Status.TaskStatus {
+			statuses = append(statuses, status)
+		}
+		sort.SliceStable(statuses, func(i, j int) bool {
+			start := func(status skyv1alpha1.TaskStatus) int64 {
+				if status.StartTime == nil {
+					return 0
+				}
+				return status.StartTime.Unix()
+			}
+			if start(statuses[i]) != start(statuses[j]) {
+				return start(statuses[i]) < start(statuses[j])
+			}
+			return statuses[i].Name < statuses[j].Name
+		})
+	}
+
+	var sources []logSource
+	for _, status := range statuses {
+		if status.PodName == "" {
+			continue
+		}
+		for _, stepStatus := range status.Steps {
+			if step != "" && stepStatus.Name != step {
+				continue
+			}
+			sources = append(sources, logSource{
+				task:    status.Name,
+				pod:     status.PodName,
+				step:    stepStatus.Name,
+				waiting: stepStatus.State == skyv1alpha1.StepStateWaiting,
+			})
+		}
+	}
+	if len(sources) == 0 {
+		if step != "" {
+			return nil, fmt.Errorf("no step %s started in workflow %s", step, workflow.Name)
+		}
+		return nil, fmt.Errorf("no step started in workflow %s", workflow.Name)
+	}
+	if len(sources) > 1 {
+		for i := range sources {
+			sources[i].prefix = fmt.Sprintf("%s/%s: ", sources[i].task, sources[i].step)
+		}
+	}
+	return sources, nil
+}
+
+// printLogs prints the logs of the steps one after the other, or all at once while following
+// them. Steps still waiting for their container are reported and skipped.
+func printLogs(ctx context.Context, o *options, sources []logSource, follow bool, out, errOut io.Writer) error {
+	var mu sync.Mutex
+	stream := func(source logSource) error {
+		logs, err := o.clientset.CoreV1().Pods(o.namespace).GetLogs(source.pod, &v1.PodLogOptions{
+			Container: source.step,
+			Follow:    follow,
+		}).Stream(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get the logs of step %s of task %s: %v", source.step, source.task, err)
+		}
+		defer logs.Close()
+
+		scanner := bufio.NewScanner(logs)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			mu.Lock()
+			fmt.Fprintf(out, "%s%s\n", source.prefix, scanner.Text())
+			mu.Unlock()
+		}
+		return scanner.Err()
+	}
+
+	sources = slices.DeleteFunc(slices.Clone(sources), func(source logSource) bool {
+		if source.waiting {
+			fmt.Fprintf(errOut, "step %s of task %s has not started\n", source.step, source.task)
+		}
+		return source.waiting
+	})
+	if !follow {
+		for _, source := range sources {
+			if err := stream(source); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	errs := make(chan error, len(sources))
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source logSource) {
+			defer wg.Done()
+			errs <- stream(source)
+		}(source)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
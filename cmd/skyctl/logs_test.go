@@ -0,0 +1,79 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Logs", func() {
+	started := func(minute int) *metav1.Time {
+		t := metav1.NewTime(time.Date(2024, 5, 1, 10, minute, 0, 0, time.UTC))
+		return &t
+	}
+	workflow := &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "build"},
+		Status: skyv1alpha1.WorkflowStatus{TaskStatus: map[string]skyv1alpha1.TaskStatus{
+			"compile": {Name: "compile", PodName: "build-compile", StartTime: started(0), Steps: []skyv1alpha1.StepStatus{
+				{Name: "fetch", State: skyv1alpha1.StepStateTerminated},
+				{Name: "make", State: skyv1alpha1.StepStateRunning},
+			}},
+			"test":   {Name: "test", Instances: []string{"test-0"}},
+			"test-0": {Name: "test-0", Parent: "test", PodName: "build-test-0", StartTime: started(5), Steps: []skyv1alpha1.StepStatus{{Name: "run", State: skyv1alpha1.StepStateWaiting}}},
+			"deploy": {Name: "deploy", WorkflowName: "build-deploy"},
+		}},
+	}
+
+	It("should prefix the lines of several steps in start order", func() {
+		sources, err := logSources(workflow, "", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(Equal([]logSource{
+			{task: "compile", pod: "build-compile", step: "fetch", prefix: "compile/fetch: "},
+			{task: "compile", pod: "build-compile", step: "make", prefix: "compile/make: "},
+			{task: "test-0", pod: "build-test-0", step: "run", prefix: "test-0/run: ", waiting: true},
+		}))
+	})
+
+	It("should print a single step without prefix", func() {
+		sources, err := logSources(workflow, "compile", "make")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(Equal([]logSource{{task: "compile", pod: "build-compile", step: "make"}}))
+	})
+
+	It("should replace a fanned-out task by its instances", func() {
+		sources, err := logSources(workflow, "test", "")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sources).To(Equal([]logSource{{task: "test-0", pod: "build-test-0", step: "run", waiting: true}}))
+	})
+
+	DescribeTable("reporting tasks without logs",
+		func(task, step, message string) {
+			_, err := logSources(workflow, task, step)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("not started", "lint", "", "task lint of workflow build has not started"),
+		Entry("running a workflow", "deploy", "", "task deploy runs workflow build-deploy, print its logs instead"),
+		Entry("without the step", "compile", "test", "no step test started in workflow build"),
+	)
+})
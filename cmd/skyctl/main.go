@@ -0,0 +1,105 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+// options holds the connection flags and the clients every command uses.
+type options struct {
+	kubeconfig string
+	context    string
+	namespace  string
+
+	client    client.WithWatch
+	clientset kubernetes.Interface
+}
+
+// connect creates the clients from the kubeconfig. The namespace defaults to the one of the
+// current context.
+func (o *options) connect() error {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.context}
+	overrides.Context.Namespace = o.namespace
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig: %v", err)
+	}
+	if o.namespace, _, err = clientConfig.Namespace(); err != nil {
+		return fmt.Errorf("failed to get the namespace: %v", err)
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if err := skyv1alpha1.AddToScheme(scheme); err != nil {
+		return err
+	}
+	if o.client, err = client.NewWithWatch(restConfig, client.Options{Scheme: scheme}); err != nil {
+		return fmt.Errorf("failed to create the client: %v", err)
+	}
+	if o.clientset, err = kubernetes.NewForConfig(restConfig); err != nil {
+		return fmt.Errorf("failed to create the clientset: %v", err)
+	}
+	return nil
+}
+
+func main() {
+	o := &options{}
+	cmd := &cobra.Command{
+		Use:          "skyctl",
+		Short:        "Submit, inspect and control workflows",
+		SilenceUsage: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return o.connect()
+		},
+	}
+	cmd.PersistentFlags().StringVar(&o.kubeconfig, "kubeconfig", "", "path to the kubeconfig file")
+	cmd.PersistentFlags().StringVar(&o.context, "context", "", "kubeconfig context to use")
+	cmd.PersistentFlags().StringVarP(&o.namespace, "namespace", "n", "", "namespace of the workflows, the one of the current context by default")
+
+	cmd.AddCommand(
+		newSubmitCommand(o),
+		newListCommand(o),
+		newGetCommand(o),
+		newWatchCommand(o),
+		newLogsCommand(o),
+		newCancelCommand(o),
+		newSuspendCommand(o),
+		newResumeCommand(o),
+		newRetryCommand(o),
+		newDeleteCommand(o),
+	)
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+func newSubmitCommand(o *options) *cobra.Command {
+	var file, from, generateName string
+	var params []string
+	var watch bool
+
+	cmd := &cobra.Command{
+		Use:   "submit (-f FILE | --from [KIND/]TEMPLATE)",
+		Short: "Submit a workflow from a manifest or a template",
+		Example: `  skyctl submit -f workflow.yaml -p revision=main
+  skyctl submit --from clusterworkflowtemplate/build -p image=app:1.2 --watch`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var workflow *skyv1alpha1.Workflow
+			var err error
+			switch {
+			case file != "" && from != "":
+				return fmt.Errorf("--file and --from are mutually exclusive")
+			case file != "":
+				workflow, err = readWorkflow(file)
+			case from != "":
+				workflow, err = templateWorkflow(from)
+			default:
+				return fmt.Errorf("one of --file or --from is required")
+			}
+			if err != nil {
+				return err
+			}
+
+			if generateName != "" {
+				workflow.Name = ""
+				workflow.GenerateName = generateName
+			}
+			if workflow.Name == "" && workflow.GenerateName == "" {
+				return fmt.Errorf("the workflow has neither a name nor a generateName, set --generate-name")
+			}
+			if workflow.Namespace == "" || cmd.Flags().Changed("namespace") {
+				workflow.Namespace = o.namespace
+			}
+			if err := setInputs(workflow, params); err != nil {
+				return err
+			}
+
+			ctx := cmd.Context()
+			if err := o.client.Create(ctx, workflow); err != nil {
+				return fmt.Errorf("failed to submit the workflow: %v", err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "workflow %s submitted\n", workflow.Name)
+			if watch {
+				// The manifest may name another namespace than the current context.
+				o.namespace = workflow.Namespace
+				return watchWorkflow(ctx, o, workflow.Name, cmd.OutOrStdout())
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&file, "file", "f", "", "workflow manifest, - reads it from stdin")
+	cmd.Flags().StringVar(&from, "from", "", "template to run, as workflowtemplate/NAME or clusterworkflowtemplate/NAME")
+	cmd.Flags().StringVar(&generateName, "generate-name", "", "prefix of the generated workflow name, the template name followed by a dash for --from")
+	cmd.Flags().StringArrayVarP(&params, "parameter", "p", nil, "input of the workflow as name=value, repeated for every input")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "watch the workflow until it completed")
+	return cmd
+}
+
+// readWorkflow decodes the workflow manifest of a file.
+func readWorkflow(file string) (*skyv1alpha1.Workflow, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", file, err)
+	}
+
+	workflow := &skyv1alpha1.Workflow{}
+	if err := yaml.UnmarshalStrict(data, workflow); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", file, err)
+	}
+	if workflow.Kind != "" && workflow.Kind != "Workflow" {
+		return nil, fmt.Errorf("%s holds a %s, not a Workflow", file, workflow.Kind)
+	}
+	workflow.ResourceVersion = ""
+	workflow.Status = skyv1alpha1.WorkflowStatus{}
+	return workflow, nil
+}
+
+// templateWorkflow returns a workflow running a template. A template without kind is a
+// WorkflowTemplate.
+func templateWorkflow(from string) (*skyv1alpha1.Workflow, error) {
+	kind, name, ok := strings.Cut(from, "/")
+	if !ok {
+		kind, name = "", from
+	}
+
+	templateRef := &skyv1alpha1.TemplateRef{Name: name}
+	switch strings.ToLower(kind) {
+	case "", "workflowtemplate", "workflowtemplates", "wftmpl":
+		templateRef.Kind = skyv1alpha1.TemplateKindWorkflowTemplate
+	case "clusterworkflowtemplate", "clusterworkflowtemplates", "cwftmpl":
+		templateRef.Kind = skyv1alpha1.TemplateKindClusterWorkflowTemplate
+	default:
+		return nil, fmt.Errorf("unknown template kind %q, expected workflowtemplate or clusterworkflowtemplate", kind)
+	}
+	if name == "" {
+		return nil, fmt.Errorf("the template name is missing in %q", from)
+	}
+
+	workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{WorkflowTemplateRef: templateRef}}
+	workflow.GenerateName = name + "-"
+	return workflow, nil
+}
+
+// setInputs overrides the inputs of a workflow with `name=value` parameters, the inputs it does
+// not declare yet are added.
+func setInputs(workflow *skyv1alpha1.Workflow, params []string) error {
+	for _, param := range params {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid parameter %q, expected name=value", param)
+		}
+		found := false
+		for i := range workflow.Spec.Inputs {
+			if workflow.Spec.Inputs[i].Name == name {
+				workflow.Spec.Inputs[i].Value = value
+				found = true
+			}
+		}
+		if !found {
+			workflow.Spec.Inputs = append(workflow.Spec.Inputs, skyv1alpha1.Input{Name: name, Value: value})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Submit", func() {
+	DescribeTable("setting inputs",
+		func(params []string, expected []skyv1alpha1.Input) {
+			workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+				Inputs: []skyv1alpha1.Input{{Name: "revision", Value: "main"}, {Name: "image"}},
+			}}
+			Expect(setInputs(workflow, params)).To(Succeed())
+			Expect(workflow.Spec.Inputs).To(Equal(expected))
+		},
+		Entry("without parameters", nil,
+			[]skyv1alpha1.Input{{Name: "revision", Value: "main"}, {Name: "image"}}),
+		Entry("overriding declared inputs", []string{"revision=v1.2", "image=app:1.2"},
+			[]skyv1alpha1.Input{{Name: "revision", Value: "v1.2"}, {Name: "image", Value: "app:1.2"}}),
+		Entry("adding undeclared inputs", []string{"debug=true"},
+			[]skyv1alpha1.Input{{Name: "revision", Value: "main"}, {Name: "image"}, {Name: "debug", Value: "true"}}),
+		Entry("keeping the equal signs of values", []string{"revision=a=b", "image="},
+			[]skyv1alpha1.Input{{Name: "revision", Value: "a=b"}, {Name: "image"}}),
+	)
+
+	It("should reject parameters without name", func() {
+		workflow := &skyv1alpha1.Workflow{}
+		Expect(setInputs(workflow, []string{"revision"})).To(MatchError(`invalid parameter "revision", expected name=value`))
+		Expect(setInputs(workflow, []string{"=main"})).To(MatchError(`invalid parameter "=main", expected name=value`))
+	})
+
+	DescribeTable("referencing templates",
+		func(from string, kind skyv1alpha1.TemplateKind, name string) {
+			workflow, err := templateWorkflow(from)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workflow.Spec.WorkflowTemplateRef).To(Equal(&skyv1alpha1.TemplateRef{Kind: kind, Name: name}))
+			Expect(workflow.GenerateName).To(Equal(name + "-"))
+			Expect(workflow.Name).To(BeEmpty())
+		},
+		Entry("without kind", "build", skyv1alpha1.TemplateKindWorkflowTemplate, "build"),
+		Entry("of a WorkflowTemplate", "workflowtemplate/build", skyv1alpha1.TemplateKindWorkflowTemplate, "build"),
+		Entry("by short name", "wftmpl/build", skyv1alpha1.TemplateKindWorkflowTemplate, "build"),
+		Entry("of a ClusterWorkflowTemplate", "ClusterWorkflowTemplate/release", skyv1alpha1.TemplateKindClusterWorkflowTemplate, "release"),
+		Entry("of a ClusterWorkflowTemplate by short name", "cwftmpl/release", skyv1alpha1.TemplateKindClusterWorkflowTemplate, "release"),
+	)
+
+	DescribeTable("rejecting template references",
+		func(from, message string) {
+			_, err := templateWorkflow(from)
+			Expect(err).To(MatchError(message))
+		},
+		Entry("of an unknown kind", "cronworkflow/nightly", `unknown template kind "cronworkflow", expected workflowtemplate or clusterworkflowtemplate`),
+		Entry("without name", "workflowtemplate/", `the template name is missing in "workflowtemplate/"`),
+	)
+
+	Context("reading a manifest", func() {
+		write := func(manifest string) string {
+			file := filepath.Join(GinkgoT().TempDir(), "workflow.yaml")
+			Expect(os.WriteFile(file, []byte(manifest), 0o600)).To(Succeed())
+			return file
+		}
+
+		It("should drop the status and resource version of an exported workflow", func() {
+			workflow, err := readWorkflow(write(`
+apiVersion: sky.my.domain/v1alpha1
+kind: Workflow
+metadata:
+  name: build
+  resourceVersion: "42"
+spec:
+  tasks:
+    - name: compile
+      steps:
+        - name: make
+          script: make
+status:
+  status: Succeeded
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workflow.Name).To(Equal("build"))
+			Expect(workflow.ResourceVersion).To(BeEmpty())
+			Expect(workflow.Status).To(Equal(skyv1alpha1.WorkflowStatus{}))
+			Expect(workflow.Spec.Tasks).To(HaveLen(1))
+		})
+
+		It("should accept a manifest without kind", func() {
+			workflow, err := readWorkflow(write("metadata:\n  generateName: build-\n"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(workflow.GenerateName).To(Equal("build-"))
+		})
+
+		It("should reject other kinds", func() {
+			file := write("apiVersion: sky.my.domain/v1alpha1\nkind: WorkflowTemplate\nmetadata:\n  name: build\n")
+			_, err := readWorkflow(file)
+			Expect(err).To(MatchError(file + " holds a WorkflowTemplate, not a Workflow"))
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := readWorkflow(write("kind: Workflow\nspec:\n  task: []\n"))
+			Expect(err).To(MatchError(ContainSubstring(`unknown field "task"`)))
+		})
+
+		It("should report missing files", func() {
+			_, err := readWorkflow(filepath.Join(GinkgoT().TempDir(), "missing.yaml"))
+			Expect(err).To(MatchError(ContainSubstring("failed to read")))
+		})
+	})
+})
@@ -0,0 +1,49 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+func TestSkyctl(t *testing.T) {
+	RegisterFailHandler(Fail)
+
+	RunSpecs(t, "Skyctl Suite")
+}
+
+// newFakeOptions returns the options of a command connected to a fake API server holding the
+// objects, in the default namespace.
+func newFakeOptions(objects ...client.Object) *options {
+	scheme := runtime.NewScheme()
+	Expect(clientgoscheme.AddToScheme(scheme)).To(Succeed())
+	Expect(skyv1alpha1.AddToScheme(scheme)).To(Succeed())
+	return &options{
+		namespace: "default",
+		client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+func newWatchCommand(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "watch WORKFLOW",
+		Short: "Print a workflow every time it changes, until it completed",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return watchWorkflow(cmd.Context(), o, args[0], cmd.OutOrStdout())
+		},
+	}
+}
+
+// watchWorkflow prints the workflow on every change until it completed. Watches closed by the API
+// server are opened again, they start with the current version of the workflow.
+func watchWorkflow(ctx context.Context, o *options, name string, out io.Writer) error {
+	workflow, err := getWorkflow(ctx, o, name)
+	if err != nil {
+		return err
+	}
+	printWorkflow(out, workflow)
+
+	for !isCompleted(workflow) {
+		workflows := &skyv1alpha1.WorkflowList{}
+		watcher, err := o.client.Watch(ctx, workflows,
+			client.InNamespace(o.namespace),
+			client.MatchingFields{"metadata.name": name},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to watch workflow %s: %v", name, err)
+		}
+		workflow, err = nextWorkflows(watcher, workflow, out)
+		watcher.Stop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nextWorkflows prints the versions of the workflow the watcher receives, and returns the last one
+// once the workflow completed or the watch was closed.
+func nextWorkflows(watcher watch.Interface, workflow *skyv1alpha1.Workflow, out io.Writer) (*skyv1alpha1.Workflow, error) {
+	for event := range watcher.ResultChan() {
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			next, ok := event.Object.(*skyv1alpha1.Workflow)
+			if !ok || next.ResourceVersion == workflow.ResourceVersion {
+				continue
+			}
+			workflow = next
+			fmt.Fprintln(out)
+			printWorkflow(out, workflow)
+			if isCompleted(workflow) {
+				return workflow, nil
+			}
+		case watch.Deleted:
+			return nil, fmt.Errorf("workflow %s was deleted", workflow.Name)
+		case watch.Error:
+			return nil, fmt.Errorf("failed to watch workflow %s: %v", workflow.Name, event.Object)
+		}
+	}
+	return workflow, nil
+}
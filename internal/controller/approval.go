@@ -0,0 +1,98 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// newApprovalStatus starts waiting for the decision on an approval task.
+func newApprovalStatus(task skyv1alpha1.Task, previous skyv1alpha1.TaskStatus, now time.Time) skyv1alpha1.TaskStatus {
+	message := task.Approval.Message
+	if message == "" {
+		message = "waiting for approval"
+	}
+	requestTime := metav1.NewTime(now)
+	return skyv1alpha1.TaskStatus{
+		Name:       task.Name,
+		Status:     skyv1alpha1.TaskStatusRunning,
+		Reason:     skyv1alpha1.TaskReasonWaitingForApproval,
+		Message:    message,
+		StartTime:  &requestTime,
+		Approval:   &skyv1alpha1.ApprovalStatus{RequestTime: &requestTime},
+		Parent:     previous.Parent,
+		Parameters: previous.Parameters,
+	}
+}
+
+// approvalTaskStatus completes an approval task once the decision annotation of the workflow is set,
+// or fails it once its timeout expired.
+func approvalTaskStatus(task skyv1alpha1.Task, status skyv1alpha1.TaskStatus, annotations map[string]string, now time.Time) skyv1alpha1.TaskStatus {
+	if status.Approval == nil || isTaskCompleted(status.Status) {
+		return status
+	}
+
+	decision := skyv1alpha1.ApprovalDecision(annotations[skyv1alpha1.ApprovalAnnotation(status.Name)])
+	approver := annotations[skyv1alpha1.ApproverAnnotation(status.Name)]
+	if decision == skyv1alpha1.ApprovalApproved || decision == skyv1alpha1.ApprovalRejected {
+		// Only the webhook records approvers, without it the groups cannot be checked.
+		if approver == "" && len(task.Approval.Groups) != 0 {
+			status.Message = fmt.Sprintf("decision on task %s has no recorded approver, the approval webhook has to be enabled to check its groups", status.Name)
+			return status
+		}
+
+		decisionTime := metav1.NewTime(now)
+		approval := *status.Approval
+		approval.Decision = decision
+		approval.Approver = approver
+		approval.Comment = annotations[skyv1alpha1.ApprovalCommentAnnotation(status.Name)]
+		approval.DecisionTime = &decisionTime
+		status.Approval = &approval
+		status.Reason = ""
+		status.CompletionTime = &decisionTime
+		status.Status = skyv1alpha1.TaskStatusSucceeded
+		if decision == skyv1alpha1.ApprovalRejected {
+			status.Status = skyv1alpha1.TaskStatusFailed
+		}
+		status.Message = string(decision)
+		if approver != "" {
+			status.Message = fmt.Sprintf("%s by %s", decision, approver)
+		}
+		return status
+	}
+
+	if task.Approval.Timeout != nil && approvalDelay(task, status, now) <= 0 {
+		completionTime := metav1.NewTime(now)
+		status.Status = skyv1alpha1.TaskStatusFailed
+		status.Reason = skyv1alpha1.TaskReasonApprovalTimedOut
+		status.Message = fmt.Sprintf("no decision within %s", task.Approval.Timeout.Duration)
+		status.CompletionTime = &completionTime
+	}
+	return status
+}
+
+// approvalDelay returns how long an approval task still waits for a decision, 0 without timeout.
+func approvalDelay(task skyv1alpha1.Task, status skyv1alpha1.TaskStatus, now time.Time) time.Duration {
+	if task.Approval == nil || task.Approval.Timeout == nil || status.Approval == nil || status.Approval.RequestTime == nil {
+		return 0
+	}
+	return status.Approval.RequestTime.Add(task.Approval.Timeout.Duration).Sub(now)
+}
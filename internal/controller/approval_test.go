@@ -0,0 +1,87 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Approval tasks", func() {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	task := skyv1alpha1.Task{
+		Name:     "release",
+		Approval: &skyv1alpha1.Approval{Timeout: &metav1.Duration{Duration: time.Hour}},
+	}
+
+	It("should wait for a decision", func() {
+		status := newApprovalStatus(task, skyv1alpha1.TaskStatus{}, now)
+		Expect(status.Status).To(Equal(skyv1alpha1.TaskStatusRunning))
+		Expect(status.Reason).To(Equal(skyv1alpha1.TaskReasonWaitingForApproval))
+		Expect(status.Message).To(Equal("waiting for approval"))
+		Expect(status.PodName).To(BeEmpty())
+		Expect(approvalDelay(task, status, now.Add(20*time.Minute))).To(Equal(40 * time.Minute))
+
+		Expect(approvalTaskStatus(task, status, nil, now.Add(20*time.Minute))).To(Equal(status))
+	})
+
+	It("should complete the task with the decision", func() {
+		status := newApprovalStatus(task, skyv1alpha1.TaskStatus{}, now)
+		annotations := map[string]string{
+			skyv1alpha1.ApprovalAnnotation("release"):        "Approved",
+			skyv1alpha1.ApprovalCommentAnnotation("release"): "ship it",
+			skyv1alpha1.ApproverAnnotation("release"):        "alice",
+		}
+
+		approved := approvalTaskStatus(task, status, annotations, now)
+		Expect(approved.Status).To(Equal(skyv1alpha1.TaskStatusSucceeded))
+		Expect(approved.Message).To(Equal("Approved by alice"))
+		Expect(approved.Approval.Decision).To(Equal(skyv1alpha1.ApprovalApproved))
+		Expect(approved.Approval.Approver).To(Equal("alice"))
+		Expect(approved.Approval.Comment).To(Equal("ship it"))
+		Expect(approved.Approval.DecisionTime.Time).To(Equal(now))
+		Expect(status.Approval.Decision).To(BeEmpty())
+
+		annotations[skyv1alpha1.ApprovalAnnotation("release")] = "Rejected"
+		Expect(approvalTaskStatus(task, status, annotations, now).Status).To(Equal(skyv1alpha1.TaskStatusFailed))
+	})
+
+	It("should ignore decisions without approver when groups are required", func() {
+		restricted := *task.DeepCopy()
+		restricted.Approval.Groups = []string{"release-managers"}
+		status := newApprovalStatus(restricted, skyv1alpha1.TaskStatus{}, now)
+
+		waiting := approvalTaskStatus(restricted, status, map[string]string{skyv1alpha1.ApprovalAnnotation("release"): "Approved"}, now)
+		Expect(waiting.Status).To(Equal(skyv1alpha1.TaskStatusRunning))
+		Expect(waiting.Message).To(ContainSubstring("no recorded approver"))
+	})
+
+	It("should fail the task once the timeout expired", func() {
+		status := newApprovalStatus(task, skyv1alpha1.TaskStatus{}, now)
+
+		timedOut := approvalTaskStatus(task, status, nil, now.Add(time.Hour))
+		Expect(timedOut.Status).To(Equal(skyv1alpha1.TaskStatusFailed))
+		Expect(timedOut.Reason).To(Equal(skyv1alpha1.TaskReasonApprovalTimedOut))
+		Expect(timedOut.Message).To(Equal("no decision within 1h0m0s"))
+	})
+})
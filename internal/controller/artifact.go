@@ -0,0 +1,143 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strconv"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+)
+
+// Environment variables read by the entrypoint to reach the artifact repository.
+const (
+	artifactEndpointEnv  = "SKY_ARTIFACT_ENDPOINT"
+	artifactBucketEnv    = "SKY_ARTIFACT_BUCKET"
+	artifactRegionEnv    = "SKY_ARTIFACT_REGION"
+	artifactInsecureEnv  = "SKY_ARTIFACT_INSECURE"
+	artifactAccessKeyEnv = "SKY_ARTIFACT_ACCESS_KEY"
+	artifactSecretKeyEnv = "SKY_ARTIFACT_SECRET_KEY"
+)
+
+// ArtifactRepository is the S3-compatible bucket the task artifacts are stored in.
+type ArtifactRepository struct {
+	Endpoint string
+	Bucket   string
+	Region   string
+	// Insecure reaches the endpoint over plain HTTP, for instance a MinIO inside the cluster.
+	Insecure bool
+	// CredentialsSecret names a Secret with the accessKey and secretKey of the bucket. It has to
+	// exist in the namespaces of the workflows.
+	CredentialsSecret string
+	// KeyPrefix is prepended to the keys of the artifacts.
+	KeyPrefix string
+}
+
+// artifactKey returns the key of an artifact, unique per run of the workflow.
+func (a *ArtifactRepository) artifactKey(workFlow *skyv1alpha1.Workflow, taskName, artifactName string) string {
+	return path.Join(a.KeyPrefix, workFlow.Namespace, workFlow.Name, string(workFlow.UID), taskName, artifactName+".tgz")
+}
+
+func (a *ArtifactRepository) env() []v1.EnvVar {
+	env := []v1.EnvVar{
+		{Name: artifactEndpointEnv, Value: a.Endpoint},
+		{Name: artifactBucketEnv, Value: a.Bucket},
+		{Name: artifactRegionEnv, Value: a.Region},
+		{Name: artifactInsecureEnv, Value: strconv.FormatBool(a.Insecure)},
+	}
+	if a.CredentialsSecret != "" {
+		for _, credential := range [][2]string{{artifactAccessKeyEnv, "accessKey"}, {artifactSecretKeyEnv, "secretKey"}} {
+			env = append(env, v1.EnvVar{
+				Name: credential[0],
+				ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+					LocalObjectReference: v1.LocalObjectReference{Name: a.CredentialsSecret},
+					Key:                  credential[1],
+				}},
+			})
+		}
+	}
+	return env
+}
+
+// addArtifacts shares a directory per artifact path between the steps, and has the first step
+// download the input artifacts before it starts and the last one upload the output artifacts once
+// it succeeded.
+func addArtifacts(pod *v1.Pod, task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow, repository ArtifactRepository) error {
+	if task.Artifacts == nil || (len(task.Artifacts.Inputs) == 0 && len(task.Artifacts.Outputs) == 0) {
+		return nil
+	}
+	if repository.Bucket == "" {
+		return fmt.Errorf("task %s uses artifacts but no artifact repository is configured", task.Name)
+	}
+	if len(pod.Spec.Containers) == 0 {
+		return fmt.Errorf("task %s uses artifacts but has no steps", task.Name)
+	}
+	// The first step downloads the artifacts before the others start and the last one uploads them
+	// once the others finished, which parallel stages would break.
+	if stages := stepStages(task.Steps); len(stages[0]) > 1 || len(stages[len(stages)-1]) > 1 {
+		return fmt.Errorf("task %s uses artifacts, its first and last steps cannot run in parallel stages", task.Name)
+	}
+
+	volumes := map[string]string{}
+	mount := func(artifactPath string) {
+		if _, ok := volumes[artifactPath]; ok {
+			return
+		}
+		name := fmt.Sprintf("internal-artifact-%d", len(volumes))
+		volumes[artifactPath] = name
+		pod.Spec.Volumes = append(pod.Spec.Volumes, v1.Volume{
+			Name:         name,
+			VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+		})
+		for i := range pod.Spec.Containers {
+			pod.Spec.Containers[i].VolumeMounts = append(pod.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+				Name:      name,
+				MountPath: artifactPath,
+			})
+		}
+	}
+
+	first, last := &pod.Spec.Containers[0], &pod.Spec.Containers[len(pod.Spec.Containers)-1]
+	for _, artifact := range task.Artifacts.Inputs {
+		if artifact.From == nil {
+			return fmt.Errorf("input artifact %s of task %s has no source", artifact.Name, task.Name)
+		}
+		// The instances of a fanned-out task upload their artifacts under their own names.
+		for _, source := range slices.Concat(workFlow.GetTasks(), workFlow.GetFinally()) {
+			if source.Name == artifact.From.Task && source.IsFanOut() {
+				return fmt.Errorf("input artifact %s of task %s comes from fanned-out task %s", artifact.Name, task.Name, source.Name)
+			}
+		}
+		mount(artifact.Path)
+		key := repository.artifactKey(workFlow, artifact.From.Task, artifact.From.Artifact)
+		first.Args = append(first.Args, "--input_artifact", fmt.Sprintf("%s=%s", key, artifact.Path))
+	}
+	for _, artifact := range task.Artifacts.Outputs {
+		mount(artifact.Path)
+		key := repository.artifactKey(workFlow, task.Name, artifact.Name)
+		last.Args = append(last.Args, "--output_artifact", fmt.Sprintf("%s=%s", key, artifact.Path))
+	}
+
+	first.Env = append(first.Env, repository.env()...)
+	if last != first {
+		last.Env = append(last.Env, repository.env()...)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Artifacts", func() {
+	workflow := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "ci", UID: "1234"}}
+	repository := ArtifactRepository{Endpoint: "minio.minio:9000", Bucket: "artifacts", Insecure: true, CredentialsSecret: "minio"}
+	task := skyv1alpha1.Task{
+		Name: "test",
+		Artifacts: &skyv1alpha1.TaskArtifacts{
+			Inputs:  []skyv1alpha1.Artifact{{Name: "binary", Path: "/bin/app", From: &skyv1alpha1.ArtifactSource{Task: "compile", Artifact: "binary"}}},
+			Outputs: []skyv1alpha1.Artifact{{Name: "coverage", Path: "/coverage"}},
+		},
+		Steps: []skyv1alpha1.Step{{Name: "unit", Image: "golang"}, {Name: "report", Image: "golang"}},
+	}
+
+	It("should download inputs in the first step and upload outputs in the last one", func() {
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addArtifacts(pod, task, workflow, repository)).To(Succeed())
+
+		first, last := pod.Spec.Containers[0], pod.Spec.Containers[1]
+		Expect(first.Args).To(ContainElements("--input_artifact", "ci/build/1234/compile/binary.tgz=/bin/app"))
+		Expect(first.Args).NotTo(ContainElement("--output_artifact"))
+		Expect(last.Args).To(ContainElements("--output_artifact", "ci/build/1234/test/coverage.tgz=/coverage"))
+		Expect(last.Env).To(ContainElement(v1.EnvVar{Name: artifactBucketEnv, Value: "artifacts"}))
+		for _, container := range pod.Spec.Containers {
+			Expect(container.VolumeMounts).To(ContainElements(
+				v1.VolumeMount{Name: "internal-artifact-0", MountPath: "/bin/app"},
+				v1.VolumeMount{Name: "internal-artifact-1", MountPath: "/coverage"},
+			))
+		}
+	})
+
+	It("should require an artifact repository", func() {
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addArtifacts(pod, task, workflow, ArtifactRepository{})).NotTo(Succeed())
+	})
+
+	It("should reject inputs from fanned-out tasks", func() {
+		workflow := workflow.DeepCopy()
+		workflow.Spec.Tasks = []skyv1alpha1.Task{{Name: "compile", WithItems: []string{"amd64", "arm64"}}, task}
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(addArtifacts(pod, task, workflow, repository)).To(MatchError("input artifact binary of task test comes from fanned-out task compile"))
+	})
+})
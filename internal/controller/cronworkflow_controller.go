@@ -0,0 +1,288 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// cronWorkflowLabelKey labels the scheduled workflows with the name of their CronWorkflow. The name
+// is shorter than theirs, which the workflow webhook keeps short enough for a label value.
+const cronWorkflowLabelKey = "cron_workflow_name"
+
+// CronWorkflowReconciler reconciles a CronWorkflow object
+type CronWorkflowReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=sky.my.domain,resources=cronworkflows,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=sky.my.domain,resources=cronworkflows/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=sky.my.domain,resources=cronworkflows/finalizers,verbs=update
+
+// Reconcile creates the Workflow of the latest due schedule, applies the concurrency policy and
+// removes the workflows beyond the history limits. It requeues itself for the next schedule.
+func (r *CronWorkflowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	cronWorkflow := &skyv1alpha1.CronWorkflow{}
+	if err := r.Get(ctx, req.NamespacedName, cronWorkflow); err != nil {
+		if apierrors.IsNotFound(err) {
+			logger.Info("CronWorkflow resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+
+		logger.Error(err, "Failed to get CronWorkflow")
+		return ctrl.Result{}, err
+	}
+
+	if !cronWorkflow.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	active, successful, failed, err := r.listWorkflows(ctx, cronWorkflow)
+	if err != nil {
+		logger.Error(err, "Failed to list Workflows")
+		return ctrl.Result{}, err
+	}
+
+	cronWorkflow.Status.Active = nil
+	for _, workflow := range active {
+		cronWorkflow.Status.Active = append(cronWorkflow.Status.Active, workflowReference(workflow))
+	}
+	for _, workflow := range successful {
+		if completion := workflow.Status.CompletionTime; completion != nil &&
+			(cronWorkflow.Status.LastSuccessfulTime == nil || cronWorkflow.Status.LastSuccessfulTime.Before(completion)) {
+			cronWorkflow.Status.LastSuccessfulTime = completion
+		}
+	}
+
+	if _err := r.deleteHistory(ctx, successful, cronWorkflow.Spec.GetSuccessfulRunsHistoryLimit()); _err != nil {
+		logger.Error(_err, "Failed to delete successful Workflows")
+		return ctrl.Result{}, _err
+	}
+	if _err := r.deleteHistory(ctx, failed, cronWorkflow.Spec.GetFailedRunsHistoryLimit()); _err != nil {
+		logger.Error(_err, "Failed to delete failed Workflows")
+		return ctrl.Result{}, _err
+	}
+
+	if cronWorkflow.Spec.Suspend {
+		cronWorkflow.Status.Message = "CronWorkflow suspended"
+		return ctrl.Result{}, r.updateStatus(ctx, cronWorkflow)
+	}
+
+	now := time.Now()
+	missed, next, err := nextSchedules(cronWorkflow, now)
+	if err != nil {
+		logger.Info("CronWorkflow has an invalid schedule", "reason", err.Error())
+		cronWorkflow.Status.Message = fmt.Sprintf("CronWorkflow has an invalid schedule: %v", err)
+		return ctrl.Result{}, r.updateStatus(ctx, cronWorkflow)
+	}
+	cronWorkflow.Status.Message = ""
+	result := ctrl.Result{RequeueAfter: next.Sub(now)}
+
+	if missed.IsZero() {
+		return result, r.updateStatus(ctx, cronWorkflow)
+	}
+
+	if deadline := cronWorkflow.Spec.StartingDeadlineSeconds; deadline != nil && missed.Add(time.Duration(*deadline)*time.Second).Before(now) {
+		logger.Info("Missed the starting deadline of the last schedule", "schedule", missed)
+		cronWorkflow.Status.Message = fmt.Sprintf("Missed the starting deadline of the run scheduled at %s", missed.Format(time.RFC3339))
+		return result, r.updateStatus(ctx, cronWorkflow)
+	}
+
+	switch cronWorkflow.Spec.GetConcurrencyPolicy() {
+	case skyv1alpha1.ConcurrencyPolicyForbid:
+		if len(active) != 0 {
+			logger.Info("Skipping run, the previous one is still running", "schedule", missed)
+			cronWorkflow.Status.Message = fmt.Sprintf("Skipped the run scheduled at %s, the previous one is still running", missed.Format(time.RFC3339))
+			// The skipped run counts as scheduled, it must not start once the previous one finished.
+			skipped := metav1.NewTime(missed)
+			cronWorkflow.Status.LastScheduleTime = &skipped
+			return result, r.updateStatus(ctx, cronWorkflow)
+		}
+	case skyv1alpha1.ConcurrencyPolicyReplace:
+		for _, workflow := range active {
+			if _err := r.Delete(ctx, workflow, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(_err) != nil {
+				logger.Error(_err, "Failed to delete replaced Workflow", "workflow", workflow.Name)
+				return ctrl.Result{}, _err
+			}
+			logger.Info("Deleted replaced Workflow", "workflow", workflow.Name)
+		}
+		cronWorkflow.Status.Active = nil
+	}
+
+	workflow, err := r.newWorkflow(cronWorkflow, missed)
+	if err != nil {
+		logger.Error(err, "Failed to build Workflow")
+		return ctrl.Result{}, err
+	}
+	switch _err := r.Create(ctx, workflow); {
+	case _err == nil:
+		logger.Info("Created scheduled Workflow", "workflow", workflow.Name, "schedule", missed)
+		cronWorkflow.Status.Active = append(cronWorkflow.Status.Active, workflowReference(workflow))
+	case apierrors.IsAlreadyExists(_err):
+		// A previous reconcile created it and failed to record the run, the next listing reports
+		// it as active.
+	case apierrors.IsInvalid(_err):
+		// The rejected workflow would be rejected again, the run is skipped.
+		logger.Error(_err, "Scheduled Workflow was rejected", "workflow", workflow.Name)
+		cronWorkflow.Status.Message = fmt.Sprintf("Skipped the run scheduled at %s, its Workflow was rejected: %v", missed.Format(time.RFC3339), _err)
+	default:
+		logger.Error(_err, "Failed to create Workflow", "workflow", workflow.Name)
+		cronWorkflow.Status.Message = fmt.Sprintf("Failed to create the Workflow of the run scheduled at %s: %v", missed.Format(time.RFC3339), _err)
+		if err := r.updateStatus(ctx, cronWorkflow); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, _err
+	}
+
+	scheduled := metav1.NewTime(missed)
+	cronWorkflow.Status.LastScheduleTime = &scheduled
+	return result, r.updateStatus(ctx, cronWorkflow)
+}
+
+func (r *CronWorkflowReconciler) updateStatus(ctx context.Context, cronWorkflow *skyv1alpha1.CronWorkflow) error {
+	if err := r.Status().Update(ctx, cronWorkflow); err != nil {
+		log.FromContext(ctx).Error(err, "Failed to update CronWorkflow status")
+		return err
+	}
+	return nil
+}
+
+// listWorkflows returns the workflows created by the CronWorkflow, split by outcome and sorted
+// oldest first.
+func (r *CronWorkflowReconciler) listWorkflows(ctx context.Context, cronWorkflow *skyv1alpha1.CronWorkflow) (active, successful, failed []*skyv1alpha1.Workflow, err error) {
+	workflows := &skyv1alpha1.WorkflowList{}
+	if err = r.List(ctx, workflows,
+		client.InNamespace(cronWorkflow.Namespace),
+		client.MatchingLabels{cronWorkflowLabelKey: cronWorkflow.Name},
+	); err != nil {
+		return nil, nil, nil, err
+	}
+
+	sort.Slice(workflows.Items, func(i, j int) bool {
+		return workflows.Items[i].CreationTimestamp.Before(&workflows.Items[j].CreationTimestamp)
+	})
+	for i := range workflows.Items {
+		workflow := &workflows.Items[i]
+		if !metav1.IsControlledBy(workflow, cronWorkflow) {
+			continue
+		}
+		switch workflow.Status.Status {
+		case skyv1alpha1.WorkFlowStatusSuccess:
+			successful = append(successful, workflow)
+		case skyv1alpha1.WorkFlowStatusFailed, skyv1alpha1.WorkFlowStatusCancel:
+			failed = append(failed, workflow)
+		default:
+			if workflow.DeletionTimestamp.IsZero() {
+				active = append(active, workflow)
+			}
+		}
+	}
+	return active, successful, failed, nil
+}
+
+// deleteHistory deletes the oldest workflows beyond the limit.
+func (r *CronWorkflowReconciler) deleteHistory(ctx context.Context, workflows []*skyv1alpha1.Workflow, limit int) error {
+	for i := 0; i < len(workflows)-limit; i++ {
+		if err := r.Delete(ctx, workflows[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newWorkflow returns the Workflow of a schedule, its name is derived from the schedule so that a
+// run is never created twice.
+func (r *CronWorkflowReconciler) newWorkflow(cronWorkflow *skyv1alpha1.CronWorkflow, scheduled time.Time) (*skyv1alpha1.Workflow, error) {
+	workflow := &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", cronWorkflow.Name, scheduled.Unix()/60),
+			Namespace: cronWorkflow.Namespace,
+			Labels:    map[string]string{cronWorkflowLabelKey: cronWorkflow.Name},
+		},
+		Spec: *cronWorkflow.Spec.WorkflowSpec.DeepCopy(),
+	}
+	if err := ctrl.SetControllerReference(cronWorkflow, workflow, r.Scheme); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+func workflowReference(workflow *skyv1alpha1.Workflow) corev1.ObjectReference {
+	return corev1.ObjectReference{
+		APIVersion: skyv1alpha1.GroupVersion.String(),
+		Kind:       skyv1alpha1.KindName,
+		Namespace:  workflow.Namespace,
+		Name:       workflow.Name,
+		UID:        workflow.UID,
+	}
+}
+
+// nextSchedules returns the latest schedule that is due and has not run yet, zero if there is
+// none, and the next schedule after now.
+func nextSchedules(cronWorkflow *skyv1alpha1.CronWorkflow, now time.Time) (missed, next time.Time, err error) {
+	location := time.Local
+	if cronWorkflow.Spec.TimeZone != "" {
+		if location, err = time.LoadLocation(cronWorkflow.Spec.TimeZone); err != nil {
+			return missed, next, fmt.Errorf("unknown time zone %s: %v", cronWorkflow.Spec.TimeZone, err)
+		}
+	}
+	schedule, err := cron.ParseStandard(cronWorkflow.Spec.Schedule)
+	if err != nil {
+		return missed, next, err
+	}
+
+	earliest := cronWorkflow.CreationTimestamp.Time
+	if cronWorkflow.Status.LastScheduleTime != nil {
+		earliest = cronWorkflow.Status.LastScheduleTime.Time
+	}
+	if deadline := cronWorkflow.Spec.StartingDeadlineSeconds; deadline != nil {
+		if start := now.Add(-time.Duration(*deadline) * time.Second); start.After(earliest) {
+			earliest = start
+		}
+	}
+
+	// Only the latest missed schedule runs, the ones before it are dropped.
+	now = now.In(location)
+	for t := schedule.Next(earliest.In(location)); !t.After(now); t = schedule.Next(t) {
+		missed = t
+	}
+	return missed, schedule.Next(now), nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CronWorkflowReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&skyv1alpha1.CronWorkflow{}).
+		Owns(&skyv1alpha1.Workflow{}).
+		Complete(r)
+}
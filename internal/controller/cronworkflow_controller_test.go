@@ -0,0 +1,181 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("CronWorkflow schedules", func() {
+	created := time.Date(2024, 6, 1, 0, 30, 0, 0, time.UTC)
+	newCronWorkflow := func(spec skyv1alpha1.CronWorkflowSpec) *skyv1alpha1.CronWorkflow {
+		return &skyv1alpha1.CronWorkflow{
+			ObjectMeta: metav1.ObjectMeta{CreationTimestamp: metav1.NewTime(created)},
+			Spec:       spec,
+		}
+	}
+
+	It("should return the latest missed schedule in the time zone", func() {
+		cronWorkflow := newCronWorkflow(skyv1alpha1.CronWorkflowSpec{Schedule: "0 2 * * *", TimeZone: "Europe/Paris"})
+
+		missed, next, err := nextSchedules(cronWorkflow, created.Add(time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missed.IsZero()).To(BeTrue())
+		Expect(next.UTC()).To(Equal(time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)))
+
+		missed, next, err = nextSchedules(cronWorkflow, created.Add(72*time.Hour))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missed.UTC()).To(Equal(time.Date(2024, 6, 4, 0, 0, 0, 0, time.UTC)))
+		Expect(next.UTC()).To(Equal(time.Date(2024, 6, 5, 0, 0, 0, 0, time.UTC)))
+	})
+
+	It("should not run a schedule twice", func() {
+		cronWorkflow := newCronWorkflow(skyv1alpha1.CronWorkflowSpec{Schedule: "@hourly"})
+		scheduled := metav1.NewTime(created.Add(30 * time.Minute))
+		cronWorkflow.Status.LastScheduleTime = &scheduled
+
+		missed, _, err := nextSchedules(cronWorkflow, created.Add(45*time.Minute))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(missed.IsZero()).To(BeTrue())
+	})
+
+	It("should reject invalid schedules and time zones", func() {
+		_, _, err := nextSchedules(newCronWorkflow(skyv1alpha1.CronWorkflowSpec{Schedule: "every day"}), created)
+		Expect(err).To(HaveOccurred())
+
+		_, _, err = nextSchedules(newCronWorkflow(skyv1alpha1.CronWorkflowSpec{Schedule: "@daily", TimeZone: "Mars/Olympus"}), created)
+		Expect(err).To(HaveOccurred())
+	})
+	It("should skip the run forbidden by a running workflow for good", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(skyv1alpha1.AddToScheme(testScheme)).To(Succeed())
+
+		cronWorkflow := &skyv1alpha1.CronWorkflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "cron-uid", CreationTimestamp: metav1.NewTime(time.Now().Add(-150 * time.Minute))},
+			Spec:       skyv1alpha1.CronWorkflowSpec{Schedule: "@hourly", ConcurrencyPolicy: skyv1alpha1.ConcurrencyPolicyForbid},
+		}
+		running := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly-previous", Namespace: "default", Labels: map[string]string{cronWorkflowLabelKey: "nightly"}},
+			Status:     skyv1alpha1.WorkflowStatus{Status: skyv1alpha1.WorkFlowStatusRunning},
+		}
+		Expect(ctrl.SetControllerReference(cronWorkflow, running, testScheme)).To(Succeed())
+		r := &CronWorkflowReconciler{
+			Client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(cronWorkflow, running).
+				WithStatusSubresource(&skyv1alpha1.CronWorkflow{}, &skyv1alpha1.Workflow{}).Build(),
+			Scheme: testScheme,
+		}
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "nightly"}}
+		countWorkflows := func() int {
+			workflows := &skyv1alpha1.WorkflowList{}
+			Expect(r.List(context.Background(), workflows)).To(Succeed())
+			return len(workflows.Items)
+		}
+
+		missed, _, err := nextSchedules(cronWorkflow, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.Reconcile(context.Background(), request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Get(context.Background(), request.NamespacedName, cronWorkflow)).To(Succeed())
+		Expect(cronWorkflow.Status.Message).To(HavePrefix("Skipped the run scheduled at"))
+		Expect(cronWorkflow.Status.LastScheduleTime.Time).To(BeTemporally("==", missed))
+		Expect(countWorkflows()).To(Equal(1))
+
+		running.Status.Status = skyv1alpha1.WorkFlowStatusSuccess
+		Expect(r.Status().Update(context.Background(), running)).To(Succeed())
+		_, err = r.Reconcile(context.Background(), request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(countWorkflows()).To(Equal(1))
+	})
+
+	It("should record the rejection of the scheduled workflow and skip the run", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(skyv1alpha1.AddToScheme(testScheme)).To(Succeed())
+
+		cronWorkflow := &skyv1alpha1.CronWorkflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "cron-uid", CreationTimestamp: metav1.NewTime(time.Now().Add(-90 * time.Minute))},
+			Spec:       skyv1alpha1.CronWorkflowSpec{Schedule: "@hourly"},
+		}
+		rejected := apierrors.NewInvalid(skyv1alpha1.GroupVersion.WithKind(skyv1alpha1.KindName).GroupKind(), "nightly",
+			field.ErrorList{field.Required(field.NewPath("spec", "tasks"), "")})
+		r := &CronWorkflowReconciler{
+			Client: fake.NewClientBuilder().WithScheme(testScheme).WithObjects(cronWorkflow).
+				WithStatusSubresource(&skyv1alpha1.CronWorkflow{}).
+				WithInterceptorFuncs(interceptor.Funcs{Create: func(context.Context, client.WithWatch, client.Object, ...client.CreateOption) error {
+					return rejected
+				}}).Build(),
+			Scheme: testScheme,
+		}
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "nightly"}}
+
+		missed, _, err := nextSchedules(cronWorkflow, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		_, err = r.Reconcile(context.Background(), request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Get(context.Background(), request.NamespacedName, cronWorkflow)).To(Succeed())
+		Expect(cronWorkflow.Status.Message).To(HavePrefix("Skipped the run scheduled at"))
+		Expect(cronWorkflow.Status.Message).To(HaveSuffix(rejected.Error()))
+		Expect(cronWorkflow.Status.LastScheduleTime.Time).To(BeTemporally("==", missed))
+		Expect(cronWorkflow.Status.Active).To(BeEmpty())
+	})
+
+	It("should not record a workflow that already exists as a new run", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(skyv1alpha1.AddToScheme(testScheme)).To(Succeed())
+
+		cronWorkflow := &skyv1alpha1.CronWorkflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "default", UID: "cron-uid", CreationTimestamp: metav1.NewTime(time.Now().Add(-90 * time.Minute))},
+			Spec:       skyv1alpha1.CronWorkflowSpec{Schedule: "@hourly"},
+		}
+		r := &CronWorkflowReconciler{Scheme: testScheme}
+		missed, _, err := nextSchedules(cronWorkflow, time.Now())
+		Expect(err).NotTo(HaveOccurred())
+		// The workflow exists but is not listed yet, like one the cache has not seen.
+		existing, err := r.newWorkflow(cronWorkflow, missed)
+		Expect(err).NotTo(HaveOccurred())
+		existing.Labels = nil
+		r.Client = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(cronWorkflow, existing).
+			WithStatusSubresource(&skyv1alpha1.CronWorkflow{}).Build()
+		request := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "nightly"}}
+
+		_, err = r.Reconcile(context.Background(), request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Get(context.Background(), request.NamespacedName, cronWorkflow)).To(Succeed())
+		Expect(cronWorkflow.Status.Message).To(BeEmpty())
+		Expect(cronWorkflow.Status.LastScheduleTime.Time).To(BeTemporally("==", missed))
+		Expect(cronWorkflow.Status.Active).To(BeEmpty())
+	})
+})
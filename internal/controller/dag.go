@@ -3,31 +3,60 @@ package controller
 import (
 	"fmt"
 	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
-	v1 "k8s.io/api/core/v1"
+	"sort"
+	"strings"
 )
 
 type Node struct {
 	Name string
 	Prev []*Node
 	Next []*Node
+	// Conditions holds the dependency condition of every Prev node, keyed by name.
+	Conditions map[string]skyv1alpha1.DependencyCondition
+	Policy     skyv1alpha1.DependencyPolicy
 }
 
 type Dag struct {
 	Nodes map[string]*Node
 }
 
+// BuildDAG links the tasks by their dependencies. Tasks may be declared in any order, a dependency
+// only has to name a task that exists somewhere in the list.
 func BuildDAG(tasks []skyv1alpha1.Task) (*Dag, error) {
 	dag := &Dag{Nodes: make(map[string]*Node)}
 	for _, task := range tasks {
+		if _, ok := dag.Nodes[task.Name]; ok {
+			return nil, fmt.Errorf("duplicate task name: %s", task.Name)
+		}
+		dag.Nodes[task.Name] = &Node{
+			Name:       task.Name,
+			Conditions: make(map[string]skyv1alpha1.DependencyCondition),
+			Policy:     task.GetDependencyPolicy(),
+		}
+	}
+	// The instances of a fanned-out task share the TaskStatus keys with the tasks.
+	for _, task := range tasks {
+		if !task.IsFanOut() {
+			continue
+		}
+		for name := range dag.Nodes {
+			if IsInstanceName(name, task.Name) {
+				return nil, fmt.Errorf("task name %s is taken by the instances of fanned-out task %s", name, task.Name)
+			}
+		}
+	}
 
-		node := &Node{Name: task.Name}
-		dag.Nodes[task.Name] = node
-
+	for _, task := range tasks {
+		node := dag.Nodes[task.Name]
 		for _, dependency := range task.Dependencies {
-			dependencyNode, ok := dag.Nodes[dependency]
+			dependencyNode, ok := dag.Nodes[dependency.Name]
 			if !ok {
-				return nil, fmt.Errorf("dependency not found: %s", dependency)
+				return nil, fmt.Errorf("task %s depends on unknown task %s", task.Name, dependency.Name)
+			}
+			if _, ok := node.Conditions[dependency.Name]; ok {
+				return nil, fmt.Errorf("task %s declares dependency %s more than once", task.Name, dependency.Name)
 			}
+			node.Conditions[dependency.Name] = dependency.GetCondition()
 			node.Prev = append(node.Prev, dependencyNode)
 			dependencyNode.Next = append(dependencyNode.Next, node)
 		}
@@ -36,50 +65,83 @@ func BuildDAG(tasks []skyv1alpha1.Task) (*Dag, error) {
 	return dag, nil
 }
 
-func (dag *Dag) hasCycle() bool {
-	for _, node := range dag.Nodes {
-		if dag.detectCycleDFS(node, make(map[*Node]bool)) {
-			return true
-		}
+// Validate checks the graph is acyclic and reports the first cycle found as a task path.
+func (dag *Dag) Validate() error {
+	if cycle := dag.Cycle(); cycle != nil {
+		return fmt.Errorf("dependency cycle detected: %s", strings.Join(cycle, " -> "))
 	}
-	return false
+	return nil
 }
 
-func (dag *Dag) hasSingleRoot() bool {
-	rootCount := 0
-	for _, node := range dag.Nodes {
-		if len(node.Prev) == 0 {
-			rootCount++
+const (
+	unvisited = iota
+	visiting
+	visited
+)
+
+// Cycle returns the first cycle found as a task path starting and ending with the same task, nil
+// when the graph is acyclic.
+func (dag *Dag) Cycle() []string {
+	state := make(map[*Node]int, len(dag.Nodes))
+	var path []*Node
+
+	var visit func(node *Node) []string
+	visit = func(node *Node) []string {
+		state[node] = visiting
+		path = append(path, node)
+		for _, next := range node.Next {
+			switch state[next] {
+			case visiting:
+				var cycle []string
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == next {
+						for _, n := range path[i:] {
+							cycle = append(cycle, n.Name)
+						}
+						break
+					}
+				}
+				return append(cycle, next.Name)
+			case unvisited:
+				if cycle := visit(next); cycle != nil {
+					return cycle
+				}
+			}
 		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
 	}
-	return rootCount == 1
-}
 
-func (dag *Dag) Validate() bool {
-	return !dag.hasCycle() && dag.hasSingleRoot()
+	for _, name := range dag.sortedNames() {
+		node := dag.Nodes[name]
+		if state[node] != unvisited {
+			continue
+		}
+		if cycle := visit(node); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
 }
 
-func (dag *Dag) detectCycleDFS(node *Node, visited map[*Node]bool) bool {
-	if visited[node] {
-		return visited[node] == true
-	}
-	visited[node] = true
-	for _, prev := range node.Prev {
-		if dag.detectCycleDFS(prev, visited) {
-			return true
-		}
+func (dag *Dag) sortedNames() []string {
+	names := make([]string, 0, len(dag.Nodes))
+	for name := range dag.Nodes {
+		names = append(names, name)
 	}
-	visited[node] = false
-	return false
+	sort.Strings(names)
+	return names
 }
 
-func (dag *Dag) GetRootNode() *Node {
-	for _, node := range dag.Nodes {
-		if len(node.Prev) == 0 {
-			return node
-		}
+// sortedTaskNames returns the keys of the task statuses in a stable order.
+func sortedTaskNames(taskStatus map[string]skyv1alpha1.TaskStatus) []string {
+	names := make([]string, 0, len(taskStatus))
+	for name := range taskStatus {
+		names = append(names, name)
 	}
-	return nil
+	sort.Strings(names)
+	return names
 }
 
 func FindSchedulableTasks(nodes []*Node, tasks []skyv1alpha1.Task) []skyv1alpha1.Task {
@@ -94,44 +156,84 @@ func FindSchedulableTasks(nodes []*Node, tasks []skyv1alpha1.Task) []skyv1alpha1
 	return nextTasks
 }
 
-func FindCompletedTasks(flow *skyv1alpha1.Workflow) []string {
-	var completedTasks []string
-	for _, status := range flow.Status.TaskStatus {
-		if status.Status == v1.PodSucceeded || status.Status == v1.PodFailed {
-			completedTasks = append(completedTasks, status.Name)
-		}
+// isTaskCompleted reports whether the task reached a phase it never leaves.
+func isTaskCompleted(phase skyv1alpha1.TaskPhase) bool {
+	switch phase {
+	case skyv1alpha1.TaskStatusSucceeded, skyv1alpha1.TaskStatusFailed, skyv1alpha1.TaskStatusSkipped, skyv1alpha1.TaskStatusCancelled:
+		return true
+	}
+	return false
+}
+
+// dependencySatisfied reports whether the upstream task meets the dependency condition. The second
+// value is false while the upstream task has not completed yet. A task skipped by its when
+// expression counts as succeeded.
+func dependencySatisfied(condition skyv1alpha1.DependencyCondition, status skyv1alpha1.TaskStatus) (bool, bool) {
+	if !isTaskCompleted(status.Status) {
+		return false, false
+	}
+
+	switch condition {
+	case skyv1alpha1.DependencyAlways:
+		return true, true
+	case skyv1alpha1.DependencyFailed:
+		return status.Status == skyv1alpha1.TaskStatusFailed, true
+	default:
+		whenFalse := status.Status == skyv1alpha1.TaskStatusSkipped && status.Reason == skyv1alpha1.TaskReasonWhenFalse
+		return status.Status == skyv1alpha1.TaskStatusSucceeded || whenFalse, true
 	}
-	return completedTasks
 }
 
-func FindSchedulableNodes(dag *Dag, completedTasks []string, taskStatus map[string]skyv1alpha1.TaskStatus) []*Node {
+// FindSchedulableNodes returns the tasks whose dependencies are satisfied and the tasks whose
+// dependencies can no longer be satisfied, which have to be skipped.
+func FindSchedulableNodes(dag *Dag, taskStatus map[string]skyv1alpha1.TaskStatus) ([]*Node, []*Node) {
 	if dag == nil || len(dag.Nodes) == 0 {
-		return []*Node{}
+		return []*Node{}, []*Node{}
 	}
 
-	var nextNodes []*Node
-	for _, node := range dag.Nodes {
+	var nextNodes, skippedNodes []*Node
+	for _, name := range dag.sortedNames() {
+		node := dag.Nodes[name]
 		if _, ok := taskStatus[node.Name]; ok {
 			continue
 		}
 
-		if node.Prev == nil {
+		if len(node.Prev) == 0 {
 			nextNodes = append(nextNodes, node)
 			continue
 		}
 
-		allPrevCompleted := false
+		var satisfied, unsatisfied int
 		for _, prev := range node.Prev {
-			if prevTask, ok := taskStatus[prev.Name]; ok {
-				if prevTask.Status == v1.PodSucceeded {
-					allPrevCompleted = true
-					break
-				}
+			prevTask, ok := taskStatus[prev.Name]
+			if !ok {
+				continue
+			}
+			met, completed := dependencySatisfied(node.Conditions[prev.Name], prevTask)
+			if !completed {
+				continue
+			}
+			if met {
+				satisfied++
+			} else {
+				unsatisfied++
 			}
 		}
-		if allPrevCompleted {
-			nextNodes = append(nextNodes, node)
+
+		switch node.Policy {
+		case skyv1alpha1.DependencyPolicyAny:
+			if satisfied > 0 {
+				nextNodes = append(nextNodes, node)
+			} else if unsatisfied == len(node.Prev) {
+				skippedNodes = append(skippedNodes, node)
+			}
+		default:
+			if satisfied == len(node.Prev) {
+				nextNodes = append(nextNodes, node)
+			} else if unsatisfied > 0 {
+				skippedNodes = append(skippedNodes, node)
+			}
 		}
 	}
-	return nextNodes
+	return nextNodes, skippedNodes
 }
@@ -0,0 +1,174 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"sigs.k8s.io/yaml"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("DAG", func() {
+	Context("When building the graph", func() {
+		It("should accept several roots and dependencies declared later", func() {
+			d, err := BuildDAG([]skyv1alpha1.Task{
+				{Name: "publish", Dependencies: []skyv1alpha1.Dependency{{Name: "lint"}, {Name: "test"}, {Name: "docs"}}},
+				{Name: "lint"},
+				{Name: "test"},
+				{Name: "docs"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Validate()).To(Succeed())
+
+			next, skipped := FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{})
+			var roots []string
+			for _, node := range next {
+				roots = append(roots, node.Name)
+			}
+			Expect(roots).To(ConsistOf("docs", "lint", "test"))
+			Expect(skipped).To(BeEmpty())
+			Expect(d.Nodes["publish"].Prev).To(HaveLen(3))
+		})
+
+		It("should accept dependencies given by task name", func() {
+			var tasks []skyv1alpha1.Task
+			Expect(yaml.Unmarshal([]byte(`
+- name: build
+- name: lint
+- name: publish
+  dependencies: [build, {name: lint, condition: Always}]
+`), &tasks)).To(Succeed())
+			Expect(tasks[2].Dependencies).To(Equal([]skyv1alpha1.Dependency{{Name: "build"}, {Name: "lint", Condition: skyv1alpha1.DependencyAlways}}))
+
+			d, err := BuildDAG(tasks)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Nodes["publish"].Conditions).To(Equal(map[string]skyv1alpha1.DependencyCondition{
+				"build": skyv1alpha1.DependencySucceeded,
+				"lint":  skyv1alpha1.DependencyAlways,
+			}))
+
+			Expect(yaml.Unmarshal([]byte(`[{name: publish, dependencies: [[build]]}]`), &tasks)).To(MatchError(ContainSubstring("neither a task name nor a dependency object")))
+		})
+
+		It("should report the missing task name", func() {
+			_, err := BuildDAG([]skyv1alpha1.Task{
+				{Name: "build", Dependencies: []skyv1alpha1.Dependency{{Name: "checkout"}}},
+			})
+			Expect(err).To(MatchError("task build depends on unknown task checkout"))
+		})
+
+		It("should report a task named like an instance of a fanned-out task", func() {
+			_, err := BuildDAG([]skyv1alpha1.Task{
+				{Name: "build", WithItems: []string{"amd64", "arm64"}},
+				{Name: "build-0"},
+			})
+			Expect(err).To(MatchError("task name build-0 is taken by the instances of fanned-out task build"))
+
+			_, err = BuildDAG([]skyv1alpha1.Task{
+				{Name: "build", WithItems: []string{"amd64", "arm64"}},
+				{Name: "build-docs"},
+				{Name: "build-"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should report the cycle path", func() {
+			d, err := BuildDAG([]skyv1alpha1.Task{
+				{Name: "a", Dependencies: []skyv1alpha1.Dependency{{Name: "c"}}},
+				{Name: "b", Dependencies: []skyv1alpha1.Dependency{{Name: "a"}}},
+				{Name: "c", Dependencies: []skyv1alpha1.Dependency{{Name: "b"}}},
+				{Name: "d"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Validate()).To(MatchError("dependency cycle detected: a -> b -> c -> a"))
+		})
+
+		It("should report a task depending on itself", func() {
+			d, err := BuildDAG([]skyv1alpha1.Task{
+				{Name: "a", Dependencies: []skyv1alpha1.Dependency{{Name: "a"}}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(d.Validate()).To(MatchError("dependency cycle detected: a -> a"))
+		})
+	})
+
+	Context("When resolving dependencies", func() {
+		tasks := []skyv1alpha1.Task{
+			{Name: "build"},
+			{Name: "test"},
+			{Name: "release", Dependencies: []skyv1alpha1.Dependency{{Name: "build"}, {Name: "test"}}},
+			{Name: "cleanup", Dependencies: []skyv1alpha1.Dependency{
+				{Name: "build", Condition: skyv1alpha1.DependencyFailed},
+				{Name: "test", Condition: skyv1alpha1.DependencyFailed},
+			}, DependencyPolicy: skyv1alpha1.DependencyPolicyAny},
+			{Name: "notify", Dependencies: []skyv1alpha1.Dependency{
+				{Name: "release", Condition: skyv1alpha1.DependencyAlways},
+			}},
+		}
+
+		names := func(nodes []*Node) []string {
+			result := []string{}
+			for _, node := range nodes {
+				result = append(result, node.Name)
+			}
+			return result
+		}
+
+		It("should wait for every parent before starting a join", func() {
+			d, err := BuildDAG(tasks)
+			Expect(err).NotTo(HaveOccurred())
+
+			next, skipped := FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{
+				"build": {Name: "build", Status: skyv1alpha1.TaskStatusSucceeded},
+				"test":  {Name: "test", Status: skyv1alpha1.TaskStatusRunning},
+			})
+			Expect(names(next)).To(BeEmpty())
+			Expect(names(skipped)).To(BeEmpty())
+
+			next, skipped = FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{
+				"build": {Name: "build", Status: skyv1alpha1.TaskStatusSucceeded},
+				"test":  {Name: "test", Status: skyv1alpha1.TaskStatusSucceeded},
+			})
+			Expect(names(next)).To(Equal([]string{"release"}))
+			Expect(names(skipped)).To(Equal([]string{"cleanup"}))
+		})
+
+		It("should run failure handlers and skip dependents of a failed task", func() {
+			d, err := BuildDAG(tasks)
+			Expect(err).NotTo(HaveOccurred())
+
+			next, skipped := FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{
+				"build": {Name: "build", Status: skyv1alpha1.TaskStatusFailed},
+				"test":  {Name: "test", Status: skyv1alpha1.TaskStatusRunning},
+			})
+			Expect(names(next)).To(Equal([]string{"cleanup"}))
+			Expect(names(skipped)).To(Equal([]string{"release"}))
+
+			next, skipped = FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{
+				"build":   {Name: "build", Status: skyv1alpha1.TaskStatusFailed},
+				"test":    {Name: "test", Status: skyv1alpha1.TaskStatusRunning},
+				"cleanup": {Name: "cleanup", Status: skyv1alpha1.TaskStatusRunning},
+				"release": {Name: "release", Status: skyv1alpha1.TaskStatusSkipped},
+			})
+			Expect(names(next)).To(Equal([]string{"notify"}))
+			Expect(names(skipped)).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,310 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	itemParameter   = "item"
+	matrixParameter = "matrix"
+)
+
+// expandTask returns the parameters of every instance of a fanned-out task, in instance order.
+func expandTask(task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow) ([]map[string]string, error) {
+	if task.Matrix != nil {
+		if len(task.WithItems) != 0 || task.WithParam != "" {
+			return nil, fmt.Errorf("matrix cannot be combined with withItems or withParam")
+		}
+		return expandMatrix(task.Matrix), nil
+	}
+
+	if task.WithParam == "" {
+		parameters := make([]map[string]string, 0, len(task.WithItems))
+		for _, item := range task.WithItems {
+			parameters = append(parameters, map[string]string{itemParameter: item})
+		}
+		return parameters, nil
+	}
+
+	param := strings.NewReplacer(workflowReplacements(workFlow)...).Replace(task.WithParam)
+	var items []json.RawMessage
+	if err := json.Unmarshal([]byte(param), &items); err != nil {
+		return nil, fmt.Errorf("withParam is not a JSON array: %v", err)
+	}
+
+	parameters := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		parameters = append(parameters, itemParameters(item))
+	}
+	return parameters, nil
+}
+
+// itemParameters exposes a JSON item as {{item}}, strings without their quotes, and the fields of
+// an object item as {{item.<field>}}.
+func itemParameters(item json.RawMessage) map[string]string {
+	parameters := map[string]string{itemParameter: string(item)}
+
+	var value string
+	if err := json.Unmarshal(item, &value); err == nil {
+		parameters[itemParameter] = value
+		return parameters
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(item, &fields); err == nil {
+		for name, field := range fields {
+			fieldValue := string(field)
+			if err := json.Unmarshal(field, &value); err == nil {
+				fieldValue = value
+			}
+			parameters[fmt.Sprintf("%s.%s", itemParameter, name)] = fieldValue
+		}
+	}
+	return parameters
+}
+
+// expandMatrix returns the cartesian product of the matrix parameters without the excluded
+// combinations, followed by the included ones. Parameters vary from the last name in
+// alphabetical order to the first.
+func expandMatrix(matrix *skyv1alpha1.Matrix) []map[string]string {
+	names := make([]string, 0, len(matrix.Parameters))
+	for name := range matrix.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var combinations []map[string]string
+	if len(names) != 0 {
+		combinations = []map[string]string{{}}
+	}
+	for _, name := range names {
+		var product []map[string]string
+		for _, combination := range combinations {
+			for _, value := range matrix.Parameters[name] {
+				next := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					next[k] = v
+				}
+				next[name] = string(value)
+				product = append(product, next)
+			}
+		}
+		combinations = product
+	}
+
+	exclude := make([]map[string]string, 0, len(matrix.Exclude))
+	for _, entry := range matrix.Exclude {
+		exclude = append(exclude, matrixEntry(entry))
+	}
+	for _, entry := range matrix.Include {
+		combinations = append(combinations, matrixEntry(entry))
+	}
+
+	var parameters []map[string]string
+	for _, combination := range combinations {
+		if matrixExcluded(exclude, combination) || slices.ContainsFunc(parameters, func(params map[string]string) bool {
+			return len(params) == len(combination) && matrixMatches(combination, params)
+		}) {
+			continue
+		}
+		params := make(map[string]string, len(combination))
+		for name, value := range combination {
+			params[fmt.Sprintf("%s.%s", matrixParameter, name)] = value
+		}
+		parameters = append(parameters, params)
+	}
+	return parameters
+}
+
+// matrixEntry returns the values of an include or exclude entry as strings.
+func matrixEntry(entry map[string]skyv1alpha1.MatrixValue) map[string]string {
+	values := make(map[string]string, len(entry))
+	for name, value := range entry {
+		values[name] = string(value)
+	}
+	return values
+}
+
+func matrixExcluded(exclude []map[string]string, combination map[string]string) bool {
+	for _, entry := range exclude {
+		if matrixMatches(entry, combination) {
+			return true
+		}
+	}
+	return false
+}
+
+// matrixMatches reports whether the parameters hold every value of the entry. The parameters are
+// either a raw combination or instance parameters prefixed with matrix.
+func matrixMatches(entry, params map[string]string) bool {
+	for name, value := range entry {
+		actual, ok := params[name]
+		if !ok {
+			actual, ok = params[fmt.Sprintf("%s.%s", matrixParameter, name)]
+		}
+		if !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// maxParallel returns how many instances of a fanned-out task may run at once, 0 means no limit.
+func maxParallel(task skyv1alpha1.Task) int {
+	if task.Matrix == nil {
+		return 0
+	}
+	return int(task.Matrix.MaxParallel)
+}
+
+// instanceName names the instance of a fanned-out task, it is also the key of its TaskStatus.
+func instanceName(taskName string, index int) string {
+	return fmt.Sprintf("%s-%d", taskName, index)
+}
+
+// IsInstanceName reports whether a name is the name of an instance of the fanned-out task, a task
+// cannot be named like it.
+func IsInstanceName(name, taskName string) bool {
+	index, ok := strings.CutPrefix(name, taskName+"-")
+	if !ok || index == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(index, 10, 0)
+	return err == nil
+}
+
+// newInstanceStatuses records the instances of a fanned-out task. Instances with a status already,
+// because their Pod was adopted, keep it.
+func newInstanceStatuses(task skyv1alpha1.Task, parameters []map[string]string, taskStatus map[string]skyv1alpha1.TaskStatus) skyv1alpha1.TaskStatus {
+	now := metav1.Now()
+	parent := skyv1alpha1.TaskStatus{
+		Name:      task.Name,
+		Status:    skyv1alpha1.TaskStatusRunning,
+		StartTime: &now,
+	}
+	for index, params := range parameters {
+		name := instanceName(task.Name, index)
+		status, ok := taskStatus[name]
+		if !ok {
+			status.Status = skyv1alpha1.TaskStatusPending
+		}
+		status.Name = name
+		status.Parent = task.Name
+		status.Parameters = params
+		taskStatus[name] = status
+		parent.Instances = append(parent.Instances, name)
+	}
+	return parent
+}
+
+// statusTask returns the task a status was recorded for, instances run their fanned-out task.
+func statusTask(tasks map[string]skyv1alpha1.Task, status skyv1alpha1.TaskStatus) skyv1alpha1.Task {
+	if status.Parent != "" {
+		return instanceTask(tasks[status.Parent], status)
+	}
+	return tasks[status.Name]
+}
+
+// instanceTask returns the task an instance runs: the fanned-out task with the instance name and
+// its parameters substituted into the scripts, arguments and environment of the steps.
+func instanceTask(task skyv1alpha1.Task, status skyv1alpha1.TaskStatus) skyv1alpha1.Task {
+	keys := make([]string, 0, len(status.Parameters))
+	for key := range status.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	replacements := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		replacements = append(replacements, fmt.Sprintf("{{%s}}", key), status.Parameters[key])
+	}
+	replacer := strings.NewReplacer(replacements...)
+
+	instance := *task.DeepCopy()
+	instance.Name = status.Name
+	instance.WithItems = nil
+	instance.WithParam = ""
+	instance.Matrix = nil
+	for i, step := range instance.Steps {
+		instance.Steps[i].Script = replacer.Replace(step.Script)
+		instance.Steps[i].Args = replacer.Replace(step.Args)
+		for j, env := range step.Env {
+			instance.Steps[i].Env[j].Value = replacer.Replace(env.Value)
+		}
+	}
+	if instance.Workflow != nil {
+		for i, input := range instance.Workflow.Inputs {
+			instance.Workflow.Inputs[i].Value = replacer.Replace(input.Value)
+		}
+	}
+	return instance
+}
+
+// aggregateInstances completes a fanned-out task once all its instances completed. It fails if
+// any instance failed and exposes every output as the JSON array of the instance values.
+func aggregateInstances(task skyv1alpha1.Task, parent skyv1alpha1.TaskStatus, taskStatus map[string]skyv1alpha1.TaskStatus) skyv1alpha1.TaskStatus {
+	var failed []string
+	for _, name := range parent.Instances {
+		status := taskStatus[name]
+		if !isTaskCompleted(status.Status) {
+			return parent
+		}
+		if status.Status == skyv1alpha1.TaskStatusFailed {
+			failed = append(failed, name)
+		}
+		if parent.CompletionTime == nil || (status.CompletionTime != nil && parent.CompletionTime.Before(status.CompletionTime)) {
+			parent.CompletionTime = status.CompletionTime
+		}
+	}
+
+	if parent.CompletionTime == nil {
+		now := metav1.Now()
+		parent.CompletionTime = &now
+	}
+	parent.Status = skyv1alpha1.TaskStatusSucceeded
+	parent.Message = ""
+	if len(failed) != 0 {
+		parent.Status = skyv1alpha1.TaskStatusFailed
+		parent.Message = fmt.Sprintf("instances failed: %s", strings.Join(failed, ", "))
+	}
+
+	parent.Outputs = nil
+	for _, output := range task.Outputs {
+		values := make([]string, 0, len(parent.Instances))
+		for _, name := range parent.Instances {
+			value := ""
+			for _, instanceOutput := range taskStatus[name].Outputs {
+				if instanceOutput.Name == output.Name {
+					value = instanceOutput.Value
+				}
+			}
+			values = append(values, value)
+		}
+		encoded, _ := json.Marshal(values)
+		parent.Outputs = append(parent.Outputs, &skyv1alpha1.Output{Name: output.Name, Value: string(encoded)})
+	}
+	return parent
+}
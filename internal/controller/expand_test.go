@@ -0,0 +1,135 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Fan-out", func() {
+	It("should expand an upstream output into instances", func() {
+		workflow := &skyv1alpha1.Workflow{
+			Status: skyv1alpha1.WorkflowStatus{TaskStatus: map[string]skyv1alpha1.TaskStatus{
+				"plan": {Name: "plan", Status: skyv1alpha1.TaskStatusSucceeded, Outputs: []*skyv1alpha1.Output{
+					{Name: "shards", Value: `["unit", {"suite": "e2e", "parallel": 2}]`},
+				}},
+			}},
+		}
+		task := skyv1alpha1.Task{
+			Name:      "test",
+			WithParam: "{{tasks.plan.outputs.shards}}",
+			Steps:     []skyv1alpha1.Step{{Name: "run", Script: "make {{item}} {{item.suite}}", Args: "{{item.parallel}}"}},
+		}
+
+		parameters, err := expandTask(task, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parameters).To(Equal([]map[string]string{
+			{"item": "unit"},
+			{"item": `{"suite": "e2e", "parallel": 2}`, "item.suite": "e2e", "item.parallel": "2"},
+		}))
+
+		taskStatus := map[string]skyv1alpha1.TaskStatus{}
+		parent := newInstanceStatuses(task, parameters, taskStatus)
+		Expect(parent.Instances).To(Equal([]string{"test-0", "test-1"}))
+
+		instance := instanceTask(task, taskStatus["test-1"])
+		Expect(instance.Name).To(Equal("test-1"))
+		Expect(instance.Steps[0].Args).To(Equal("2"))
+		Expect(task.Steps[0].Args).To(Equal("{{item.parallel}}"))
+	})
+
+	It("should reject a parameter that is not a JSON array", func() {
+		_, err := expandTask(skyv1alpha1.Task{Name: "test", WithParam: "unit"}, &skyv1alpha1.Workflow{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should expand a matrix without excluded combinations", func() {
+		task := skyv1alpha1.Task{
+			Name: "build",
+			Matrix: &skyv1alpha1.Matrix{
+				Parameters: map[string][]skyv1alpha1.MatrixValue{"os": {"linux", "darwin"}, "arch": {"amd64", "arm64"}},
+				Exclude:    []map[string]skyv1alpha1.MatrixValue{{"os": "darwin", "arch": "amd64"}},
+				Include:    []map[string]skyv1alpha1.MatrixValue{{"os": "linux", "arch": "amd64"}, {"os": "windows", "arch": "amd64"}},
+			},
+			Steps: []skyv1alpha1.Step{{
+				Name:   "build",
+				Script: "GOOS={{matrix.os}} GOARCH={{matrix.arch}} go build",
+				Env:    []v1.EnvVar{{Name: "TARGET", Value: "{{matrix.os}}/{{matrix.arch}}"}},
+			}},
+		}
+
+		parameters, err := expandTask(task, &skyv1alpha1.Workflow{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parameters).To(Equal([]map[string]string{
+			{"matrix.arch": "amd64", "matrix.os": "linux"},
+			{"matrix.arch": "arm64", "matrix.os": "linux"},
+			{"matrix.arch": "arm64", "matrix.os": "darwin"},
+			{"matrix.arch": "amd64", "matrix.os": "windows"},
+		}))
+
+		instance := instanceTask(task, skyv1alpha1.TaskStatus{Name: "build-2", Parent: "build", Parameters: parameters[2]})
+		Expect(instance.Steps[0].Script).To(Equal("GOOS=darwin GOARCH=arm64 go build"))
+		Expect(instance.Steps[0].Env).To(Equal([]v1.EnvVar{{Name: "TARGET", Value: "darwin/arm64"}}))
+		Expect(task.Steps[0].Env[0].Value).To(Equal("{{matrix.os}}/{{matrix.arch}}"))
+		Expect(instance.IsFanOut()).To(BeFalse())
+
+		task.WithItems = []string{"a"}
+		_, err = expandTask(task, &skyv1alpha1.Workflow{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should accept numbers and booleans as matrix values", func() {
+		matrix := &skyv1alpha1.Matrix{}
+		Expect(yaml.Unmarshal([]byte(`
+parameters:
+  go: [1.21, 1.22, "1.20"]
+  race: [true]
+exclude:
+  - go: 1.21
+    race: true
+`), matrix)).To(Succeed())
+
+		Expect(expandMatrix(matrix)).To(Equal([]map[string]string{
+			{"matrix.go": "1.22", "matrix.race": "true"},
+			{"matrix.go": "1.20", "matrix.race": "true"},
+		}))
+
+		Expect(yaml.Unmarshal([]byte(`parameters: {go: [{version: 1.21}]}`), matrix)).To(MatchError(ContainSubstring("is not a string, a number or a boolean")))
+	})
+
+	It("should complete once every instance completed", func() {
+		task := skyv1alpha1.Task{Name: "test", WithItems: []string{"a", "b"}, Outputs: []skyv1alpha1.TaskOutput{{Name: "report"}}}
+		parent := skyv1alpha1.TaskStatus{Name: "test", Status: skyv1alpha1.TaskStatusRunning, Instances: []string{"test-0", "test-1"}}
+		taskStatus := map[string]skyv1alpha1.TaskStatus{
+			"test-0": {Name: "test-0", Status: skyv1alpha1.TaskStatusSucceeded, Outputs: []*skyv1alpha1.Output{{Name: "report", Value: "ok"}}},
+			"test-1": {Name: "test-1", Status: skyv1alpha1.TaskStatusRunning},
+		}
+
+		Expect(aggregateInstances(task, parent, taskStatus).Status).To(Equal(skyv1alpha1.TaskStatusRunning))
+
+		taskStatus["test-1"] = skyv1alpha1.TaskStatus{Name: "test-1", Status: skyv1alpha1.TaskStatusFailed}
+		parent = aggregateInstances(task, parent, taskStatus)
+		Expect(parent.Status).To(Equal(skyv1alpha1.TaskStatusFailed))
+		Expect(parent.Outputs).To(Equal([]*skyv1alpha1.Output{{Name: "report", Value: `["ok",""]`}}))
+	})
+})
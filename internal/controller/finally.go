@@ -0,0 +1,86 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+// validateFinally rejects tasks depending on finally tasks, they would wait for each other.
+func validateFinally(tasks, finally []skyv1alpha1.Task) error {
+	finallyNames := make(map[string]bool, len(finally))
+	for _, task := range finally {
+		finallyNames[task.Name] = true
+	}
+	for _, task := range tasks {
+		for _, dependency := range task.Dependencies {
+			if finallyNames[dependency.Name] {
+				return fmt.Errorf("task %s depends on finally task %s", task.Name, dependency.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// holdFinally removes the finally tasks from the nodes while some tasks are still to complete.
+func holdFinally(nodes []*Node, finally map[string]bool, tasksCompleted bool) []*Node {
+	if tasksCompleted {
+		return nodes
+	}
+	held := make([]*Node, 0, len(nodes))
+	for _, node := range nodes {
+		if !finally[node.Name] {
+			held = append(held, node)
+		}
+	}
+	return held
+}
+
+// tasksOutcome reports whether every task completed and returns the names of the failed ones.
+func tasksOutcome(tasks []skyv1alpha1.Task, taskStatus map[string]skyv1alpha1.TaskStatus) (bool, []string) {
+	completed := true
+	var failed []string
+	for _, task := range tasks {
+		status, ok := taskStatus[task.Name]
+		if !ok || !isTaskCompleted(status.Status) {
+			completed = false
+			continue
+		}
+		if status.Status == skyv1alpha1.TaskStatusFailed {
+			failed = append(failed, task.Name)
+		}
+	}
+	return completed, failed
+}
+
+// exitStatus returns the outcome of the tasks, finally tasks excluded, and the names of the failed
+// ones. The outcome is Running until every task completed.
+func exitStatus(workflow *skyv1alpha1.Workflow) (skyv1alpha1.WorkStatus, []string) {
+	completed, failed := tasksOutcome(workflow.GetTasks(), workflow.Status.TaskStatus)
+	switch {
+	case !completed:
+		return skyv1alpha1.WorkFlowStatusRunning, failed
+	case workflow.Spec.Cancel:
+		return skyv1alpha1.WorkFlowStatusCancel, failed
+	case len(failed) != 0:
+		return skyv1alpha1.WorkFlowStatusFailed, failed
+	default:
+		return skyv1alpha1.WorkFlowStatusSuccess, failed
+	}
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"slices"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Finally tasks", func() {
+	newWorkflow := func() *skyv1alpha1.Workflow {
+		return &skyv1alpha1.Workflow{
+			Spec: skyv1alpha1.WorkflowSpec{
+				Tasks: []skyv1alpha1.Task{{Name: "build"}, {Name: "test", Dependencies: []skyv1alpha1.Dependency{{Name: "build"}}}},
+				Finally: []skyv1alpha1.Task{
+					{Name: "teardown"},
+					{Name: "report", Dependencies: []skyv1alpha1.Dependency{{Name: "teardown"}}},
+				},
+			},
+			Status: skyv1alpha1.WorkflowStatus{TaskStatus: map[string]skyv1alpha1.TaskStatus{}},
+		}
+	}
+
+	It("should wait for every task to complete", func() {
+		workflow := newWorkflow()
+		d, err := BuildDAG(slices.Concat(workflow.Spec.Tasks, workflow.Spec.Finally))
+		Expect(err).NotTo(HaveOccurred())
+		finally := map[string]bool{"teardown": true, "report": true}
+
+		workflow.Status.TaskStatus["build"] = skyv1alpha1.TaskStatus{Name: "build", Status: skyv1alpha1.TaskStatusFailed}
+		next, skipped := FindSchedulableNodes(d, workflow.Status.TaskStatus)
+		completed, failed := tasksOutcome(workflow.Spec.Tasks, workflow.Status.TaskStatus)
+		Expect(completed).To(BeFalse())
+		Expect(holdFinally(next, finally, completed)).To(BeEmpty())
+		Expect(holdFinally(skipped, finally, completed)).To(HaveLen(1))
+
+		workflow.Status.TaskStatus["test"] = skyv1alpha1.TaskStatus{Name: "test", Status: skyv1alpha1.TaskStatusSkipped}
+		next, _ = FindSchedulableNodes(d, workflow.Status.TaskStatus)
+		completed, failed = tasksOutcome(workflow.Spec.Tasks, workflow.Status.TaskStatus)
+		Expect(completed).To(BeTrue())
+		Expect(failed).To(Equal([]string{"build"}))
+		next = holdFinally(next, finally, completed)
+		Expect(next).To(HaveLen(1))
+		Expect(next[0].Name).To(Equal("teardown"))
+	})
+
+	It("should expose the outcome of the tasks", func() {
+		workflow := newWorkflow()
+		workflow.Spec.Finally[0].Steps = []skyv1alpha1.Step{{Name: "notify", Script: "echo {{workflow.status}} {{workflow.failedTasks}}"}}
+		Expect(workflowReplacements(workflow)).To(ContainElements("{{workflow.status}}", string(skyv1alpha1.WorkFlowStatusRunning)))
+
+		workflow.Status.TaskStatus["build"] = skyv1alpha1.TaskStatus{Name: "build", Status: skyv1alpha1.TaskStatusSucceeded}
+		workflow.Status.TaskStatus["test"] = skyv1alpha1.TaskStatus{Name: "test", Status: skyv1alpha1.TaskStatusFailed}
+		status, failed := exitStatus(workflow)
+		Expect(status).To(Equal(skyv1alpha1.WorkFlowStatusFailed))
+		Expect(failed).To(Equal([]string{"test"}))
+
+		workflow.Spec.Cancel = true
+		status, _ = exitStatus(workflow)
+		Expect(status).To(Equal(skyv1alpha1.WorkFlowStatusCancel))
+	})
+
+	It("should reject tasks depending on finally tasks", func() {
+		workflow := newWorkflow()
+		Expect(validateFinally(workflow.Spec.Tasks, workflow.Spec.Finally)).To(Succeed())
+
+		workflow.Spec.Tasks[1].Dependencies = []skyv1alpha1.Dependency{{Name: "report"}}
+		Expect(validateFinally(workflow.Spec.Tasks, workflow.Spec.Finally)).To(MatchError("task test depends on finally task report"))
+	})
+})
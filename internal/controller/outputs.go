@@ -0,0 +1,281 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// offloadedOutputPrefix starts the log lines the entrypoint writes the outputs too large for the
+// termination message to.
+const offloadedOutputPrefix = "::sky-output::"
+
+// terminationOutput is an output as written by the entrypoint to the termination message.
+type terminationOutput struct {
+	Name      string `json:"name"`
+	Value     string `json:"value"`
+	Offloaded bool   `json:"offloaded,omitempty"`
+}
+
+// maxOutputsSize is the size of the data a ConfigMap holds at most.
+const maxOutputsSize = 1024 * 1024
+
+// errOutputsTooLarge is returned when the outputs of a run no longer fit into its ConfigMap.
+var errOutputsTooLarge = errors.New("outputs too large")
+
+// outputsConfigMapName names the ConfigMap holding the large outputs of a run.
+func outputsConfigMapName(workflowName string) string {
+	return fmt.Sprintf("%s-outputs", workflowName)
+}
+
+func outputKey(taskName, outputName string) string {
+	return fmt.Sprintf("%s.%s", taskName, outputName)
+}
+
+// parseTerminationOutputs converts a termination message into outputs, the offloaded ones
+// referencing the ConfigMap of the workflow.
+func parseTerminationOutputs(message, taskName, workflowName string) ([]*skyv1alpha1.Output, error) {
+	var entries []terminationOutput
+	if err := json.Unmarshal([]byte(message), &entries); err != nil {
+		return nil, err
+	}
+	outputs := make([]*skyv1alpha1.Output, 0, len(entries))
+	for _, entry := range entries {
+		output := &skyv1alpha1.Output{Name: entry.Name, Value: entry.Value}
+		if entry.Offloaded {
+			output.ValueFrom = &skyv1alpha1.OutputSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+				LocalObjectReference: v1.LocalObjectReference{Name: outputsConfigMapName(workflowName)},
+				Key:                  outputKey(taskName, entry.Name),
+			}}
+		}
+		outputs = append(outputs, output)
+	}
+	return outputs, nil
+}
+
+// parseOffloadedOutputs extracts the outputs written to the logs of a step. An output written more
+// than once keeps its last value.
+func parseOffloadedOutputs(logs io.Reader) (map[string]string, error) {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(logs)
+	scanner.Buffer(make([]byte, 0, 64*1024), 8*1024*1024)
+	for scanner.Scan() {
+		line, ok := strings.CutPrefix(scanner.Text(), offloadedOutputPrefix)
+		if !ok {
+			continue
+		}
+		name, encoded, ok := strings.Cut(line, "::")
+		if !ok {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("output %s: %v", name, err)
+		}
+		values[name] = string(value)
+	}
+	return values, scanner.Err()
+}
+
+// hasOffloadedOutputs reports whether some outputs of a task are missing their value.
+func hasOffloadedOutputs(status skyv1alpha1.TaskStatus) bool {
+	for _, output := range status.Outputs {
+		if output.ValueFrom != nil && output.Value == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// outputsContainer returns the step whose termination message the outputs of a task come from.
+func outputsContainer(_pod *v1.Pod) string {
+	for i := len(_pod.Status.ContainerStatuses) - 1; i >= 0; i-- {
+		containerStatus := _pod.Status.ContainerStatuses[i]
+		if containerStatus.State.Terminated != nil && containerStatus.State.Terminated.Message != "" {
+			return containerStatus.Name
+		}
+	}
+	return ""
+}
+
+// storeOffloadedOutputs reads the outputs of a completed task too large for the termination message
+// from the logs of its step and stores them in the ConfigMap of the workflow, which outlives the Pod.
+func (r *WorkflowReconciler) storeOffloadedOutputs(ctx context.Context, workflow *skyv1alpha1.Workflow, status *skyv1alpha1.TaskStatus, _pod *v1.Pod) error {
+	logger := log.FromContext(ctx)
+
+	if !isTaskCompleted(status.Status) || !hasOffloadedOutputs(*status) {
+		return nil
+	}
+	if r.KubeClient == nil {
+		return fmt.Errorf("task %s has outputs in its logs but no client can read them", status.Name)
+	}
+
+	container := outputsContainer(_pod)
+	logs, err := r.KubeClient.CoreV1().Pods(_pod.Namespace).GetLogs(_pod.Name, &v1.PodLogOptions{Container: container}).Stream(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the logs of step %s of task %s: %v", container, status.Name, err)
+	}
+	defer logs.Close()
+	values, err := parseOffloadedOutputs(logs)
+	if err != nil {
+		return fmt.Errorf("failed to read the outputs of task %s: %v", status.Name, err)
+	}
+
+	data := map[string]string{}
+	for _, output := range status.Outputs {
+		if output.ValueFrom == nil {
+			continue
+		}
+		value, ok := values[output.Name]
+		if !ok {
+			return fmt.Errorf("output %s of task %s not found in the logs of step %s", output.Name, status.Name, container)
+		}
+		data[output.ValueFrom.ConfigMapKeyRef.Key] = value
+		output.Value = value
+	}
+
+	if err := r.writeOutputs(ctx, workflow, data); err != nil {
+		if !errors.Is(err, errOutputsTooLarge) && !apierrors.IsRequestEntityTooLargeError(err) && !apierrors.IsInvalid(err) {
+			return err
+		}
+		// Writing them again would fail the same way, the task fails instead of blocking the run.
+		logger.Info("Task outputs cannot be stored", "task", status.Name, "reason", err.Error())
+		failOutputs(status, err)
+		return nil
+	}
+	logger.Info("Stored large task outputs", "task", status.Name, "configMap", outputsConfigMapName(workflow.Name))
+	return nil
+}
+
+// failOutputs fails a task whose outputs could not be stored, and drops the offloaded outputs that
+// have no value to load.
+func failOutputs(status *skyv1alpha1.TaskStatus, err error) {
+	status.Status = skyv1alpha1.TaskStatusFailed
+	status.Reason = skyv1alpha1.TaskReasonOutputsTooLarge
+	status.Message = fmt.Sprintf("failed to store the outputs of task %s: %v", status.Name, err)
+	status.Outputs = slices.DeleteFunc(status.Outputs, func(output *skyv1alpha1.Output) bool {
+		return output.ValueFrom != nil
+	})
+}
+
+// writeOutputs adds outputs to the ConfigMap of the workflow, creating it on first use. It fails
+// with errOutputsTooLarge when the outputs of the run no longer fit into the ConfigMap.
+func (r *WorkflowReconciler) writeOutputs(ctx context.Context, workflow *skyv1alpha1.Workflow, data map[string]string) error {
+	configMap := &v1.ConfigMap{}
+	err := r.Client.Get(ctx, client.ObjectKey{Namespace: workflow.Namespace, Name: outputsConfigMapName(workflow.Name)}, configMap)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	exists := err == nil
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	for key, value := range data {
+		configMap.Data[key] = value
+	}
+
+	size := 0
+	for key, value := range configMap.Data {
+		size += len(key) + len(value)
+	}
+	if size > maxOutputsSize {
+		return fmt.Errorf("%w: the outputs of the run take %d bytes, ConfigMap %s holds at most %d", errOutputsTooLarge, size, outputsConfigMapName(workflow.Name), maxOutputsSize)
+	}
+
+	if !exists {
+		return r.Client.Create(ctx, newOutputsConfigMap(workflow, configMap.Data))
+	}
+	return r.Client.Update(ctx, configMap)
+}
+
+// newOutputsConfigMap returns the ConfigMap holding the large outputs of a run, controlled by the
+// workflow so that it is deleted with it.
+func newOutputsConfigMap(workFlow *skyv1alpha1.Workflow, data map[string]string) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      outputsConfigMapName(workFlow.Name),
+			Namespace: workFlow.Namespace,
+			Labels: map[string]string{
+				workflowLabelKey: workFlow.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(workFlow, schema.GroupVersionKind{
+					Kind:    skyv1alpha1.KindName,
+					Group:   skyv1alpha1.GroupVersion.Group,
+					Version: skyv1alpha1.GroupVersion.Version,
+				}),
+			},
+		},
+		Data: data,
+	}
+}
+
+// loadOffloadedOutputs fills in the values of the outputs stored in the ConfigMap of the workflow,
+// so that they are substituted like any other output. clearOffloadedOutputs removes them again
+// before the status is written.
+func (r *WorkflowReconciler) loadOffloadedOutputs(ctx context.Context, workflow *skyv1alpha1.Workflow, taskStatus map[string]skyv1alpha1.TaskStatus) error {
+	var configMap *v1.ConfigMap
+	for _, status := range taskStatus {
+		if !isTaskCompleted(status.Status) || !hasOffloadedOutputs(status) {
+			continue
+		}
+		if configMap == nil {
+			configMap = &v1.ConfigMap{}
+			if err := r.Client.Get(ctx, client.ObjectKey{Namespace: workflow.Namespace, Name: outputsConfigMapName(workflow.Name)}, configMap); err != nil {
+				return err
+			}
+		}
+		for _, output := range status.Outputs {
+			if output.ValueFrom == nil || output.Value != "" {
+				continue
+			}
+			value, ok := configMap.Data[output.ValueFrom.ConfigMapKeyRef.Key]
+			if !ok {
+				return fmt.Errorf("output %s of task %s not found in ConfigMap %s", output.Name, status.Name, configMap.Name)
+			}
+			output.Value = value
+		}
+	}
+	return nil
+}
+
+// clearOffloadedOutputs keeps the values stored in the ConfigMap of the workflow out of its status.
+func clearOffloadedOutputs(workflow *skyv1alpha1.Workflow) {
+	for _, status := range workflow.Status.TaskStatus {
+		for _, output := range status.Outputs {
+			if output.ValueFrom != nil {
+				output.Value = ""
+			}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Large outputs", func() {
+	It("should reference the ConfigMap for the offloaded outputs", func() {
+		outputs, err := parseTerminationOutputs(`[{"name":"small","value":"1"},{"name":"report","value":"","offloaded":true}]`, "test", "build")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(outputs).To(HaveLen(2))
+		Expect(outputs[0]).To(Equal(&skyv1alpha1.Output{Name: "small", Value: "1"}))
+		Expect(outputs[1].ValueFrom.ConfigMapKeyRef.Name).To(Equal("build-outputs"))
+		Expect(outputs[1].ValueFrom.ConfigMapKeyRef.Key).To(Equal("test.report"))
+	})
+
+	It("should read the offloaded outputs from the logs", func() {
+		report := strings.Repeat("x", 5000)
+		logs := "running tests\n" +
+			"::sky-output::report::" + base64.StdEncoding.EncodeToString([]byte("stale")) + "\n" +
+			"::sky-output::report::" + base64.StdEncoding.EncodeToString([]byte(report)) + "\n" +
+			"::sky-output::malformed\n"
+
+		values, err := parseOffloadedOutputs(strings.NewReader(logs))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(values).To(Equal(map[string]string{"report": report}))
+	})
+
+	It("should substitute the stored outputs but keep them out of the status", func() {
+		workflow := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "default"}}
+		r := &WorkflowReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			newOutputsConfigMap(workflow, map[string]string{"test.report": "large"}),
+		).Build()}
+
+		outputs, err := parseTerminationOutputs(`[{"name":"report","value":"","offloaded":true}]`, "test", "build")
+		Expect(err).NotTo(HaveOccurred())
+		workflow.Status.TaskStatus = map[string]skyv1alpha1.TaskStatus{
+			"test": {Name: "test", Status: skyv1alpha1.TaskStatusSucceeded, Outputs: outputs},
+		}
+
+		Expect(r.loadOffloadedOutputs(context.Background(), workflow, workflow.Status.TaskStatus)).To(Succeed())
+		Expect(workflowReplacements(workflow)).To(ContainElements("{{tasks.test.outputs.report}}", "large"))
+
+		clearOffloadedOutputs(workflow)
+		Expect(workflow.Status.TaskStatus["test"].Outputs[0].Value).To(BeEmpty())
+		Expect(hasOffloadedOutputs(workflow.Status.TaskStatus["test"])).To(BeTrue())
+	})
+	It("should fail the task once the outputs of the run do not fit into the ConfigMap", func() {
+		workflow := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "default"}}
+		r := &WorkflowReconciler{Client: fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(
+			newOutputsConfigMap(workflow, map[string]string{"test.report": strings.Repeat("x", maxOutputsSize-1024)}),
+		).Build()}
+
+		Expect(r.writeOutputs(context.Background(), workflow, map[string]string{"lint.report": strings.Repeat("y", 512)})).To(Succeed())
+		err := r.writeOutputs(context.Background(), workflow, map[string]string{"scan.report": strings.Repeat("z", 1024)})
+		Expect(err).To(MatchError(errOutputsTooLarge))
+
+		outputs, parseErr := parseTerminationOutputs(`[{"name":"small","value":"1"},{"name":"report","value":"","offloaded":true}]`, "scan", "build")
+		Expect(parseErr).NotTo(HaveOccurred())
+		status := &skyv1alpha1.TaskStatus{Name: "scan", Status: skyv1alpha1.TaskStatusSucceeded, Outputs: outputs}
+		failOutputs(status, err)
+		Expect(status.Status).To(Equal(skyv1alpha1.TaskStatusFailed))
+		Expect(status.Reason).To(Equal(skyv1alpha1.TaskReasonOutputsTooLarge))
+		Expect(status.Message).To(ContainSubstring("ConfigMap build-outputs holds at most"))
+		Expect(status.Outputs).To(Equal([]*skyv1alpha1.Output{{Name: "small", Value: "1"}}))
+		Expect(hasOffloadedOutputs(*status)).To(BeFalse())
+
+		configMap := newOutputsConfigMap(workflow, nil)
+		Expect(r.Client.Get(context.Background(), client.ObjectKeyFromObject(configMap), configMap)).To(Succeed())
+		Expect(configMap.Data).To(HaveKey("lint.report"))
+		Expect(configMap.Data).NotTo(HaveKey("scan.report"))
+	})
+})
@@ -7,7 +7,9 @@ import (
 	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/selection"
 	"strings"
 )
 
@@ -23,24 +25,29 @@ const (
 	downwardDir            = "/tmp/sky/downward"
 	terminationMessagePath = "/tmp/termination-log"
 	taskLabelKey           = "task_name"
+	workflowLabelKey       = "workflow_name"
+	taskAttemptLabelKey    = "task_attempt"
 )
 
-func generatePod(ctx context.Context, task skyv1alpha1.Task, steps []skyv1alpha1.Step, taskName, podName string, taskOutput []skyv1alpha1.TaskOutput, workFlow *skyv1alpha1.Workflow) (*v1.Pod, error) {
-	pod := &v1.Pod{}
-	pod.Namespace = workFlow.Namespace
-	pod.Name = podName
-	pod.Labels = map[string]string{
-		taskLabelKey: taskName,
-	}
-	pod.Annotations = map[string]string{
-		"0": "0",
-	}
-	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+// InitContainerName names the init container installing the entrypoint, steps cannot use it.
+const InitContainerName = "init-step"
 
-	copySteps := steps
+// TaskPodSelector matches the Pods created for workflow tasks, and the ConfigMaps of their large
+// outputs. It is used to restrict the informer caches to the objects the controller owns.
+func TaskPodSelector() labels.Selector {
+	requirement, _ := labels.NewRequirement(workflowLabelKey, selection.Exists, nil)
+	return labels.NewSelector().Add(*requirement)
+}
 
-	replacements := []string{}
-	for _, input := range workFlow.Spec.Inputs {
+// workflowReplacements returns the placeholder and value pairs of the workflow inputs, of the
+// outcome of the tasks and of the outputs of the tasks that already ran, for use with strings.NewReplacer.
+func workflowReplacements(workFlow *skyv1alpha1.Workflow) []string {
+	status, failed := exitStatus(workFlow)
+	replacements := []string{
+		"{{workflow.status}}", string(status),
+		"{{workflow.failedTasks}}", strings.Join(failed, ","),
+	}
+	for _, input := range workFlow.GetInputs() {
 		replacements = append(replacements, fmt.Sprintf("{{inputs.%s}}", input.Name), input.Value)
 	}
 
@@ -49,25 +56,54 @@ func generatePod(ctx context.Context, task skyv1alpha1.Task, steps []skyv1alpha1
 			replacements = append(replacements, fmt.Sprintf("{{tasks.%s.outputs.%s}}", taskStatus.Name, output.Name), output.Value)
 		}
 	}
+	return replacements
+}
 
-	replacer := strings.NewReplacer(replacements...)
-	for i, step := range copySteps {
+func generatePod(ctx context.Context, task skyv1alpha1.Task, steps []skyv1alpha1.Step, taskName, podName string, taskOutput []skyv1alpha1.TaskOutput, workFlow *skyv1alpha1.Workflow) (*v1.Pod, error) {
+	pod := &v1.Pod{}
+	pod.Namespace = workFlow.Namespace
+	pod.Name = podName
+	pod.Labels = map[string]string{
+		taskLabelKey:     taskName,
+		workflowLabelKey: workFlow.Name,
+	}
+	pod.Annotations = map[string]string{
+		"0": "0",
+	}
+	pod.Spec.RestartPolicy = v1.RestartPolicyNever
+
+	// The steps are copied, they belong to the spec of the workflow.
+	copySteps := make([]skyv1alpha1.Step, len(steps))
+	replacer := strings.NewReplacer(workflowReplacements(workFlow)...)
+	for i, step := range steps {
+		copySteps[i] = *step.DeepCopy()
 		copySteps[i].Args = replacer.Replace(step.Args)
 		copySteps[i].Script = replacer.Replace(step.Script)
+		for j := range copySteps[i].Env {
+			copySteps[i].Env[j].Value = replacer.Replace(copySteps[i].Env[j].Value)
+		}
 	}
 
-	pod.Spec.InitContainers = initContainers(copySteps)
+	pod.Spec.InitContainers = append(initContainers(copySteps), sidecarContainers(task.Sidecars, replacer)...)
 
 	outputs := ""
 	for _, output := range taskOutput {
 		outputs = fmt.Sprintf("%s %s", outputs, output.Name)
 	}
 
-	containers, err := stepContainers(steps, strings.TrimSpace(outputs))
+	containers, err := stepContainers(copySteps, strings.TrimSpace(outputs), task.GetStageFailurePolicy())
 	if err != nil {
 		return nil, err
 	}
 
+	workspaces, workspaceMounts, err := workspaceVolumes(task, workFlow)
+	if err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		containers[i].VolumeMounts = append(containers[i].VolumeMounts, workspaceMounts...)
+	}
+
 	activeDeadlineSeconds := int64(task.GetTimeout().Seconds())
 	pod.Spec.ActiveDeadlineSeconds = &activeDeadlineSeconds
 
@@ -113,6 +149,9 @@ func generatePod(ctx context.Context, task skyv1alpha1.Task, steps []skyv1alpha1
 			},
 		},
 	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, workspaces...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, task.Volumes...)
+	applyPodTemplate(pod, MergePodTemplates(workFlow.GetPodTemplate(), task.PodTemplate))
 
 	pod.ObjectMeta.OwnerReferences = []metav1.OwnerReference{
 		*metav1.NewControllerRef(workFlow, schema.GroupVersionKind{
@@ -125,6 +164,86 @@ func generatePod(ctx context.Context, task skyv1alpha1.Task, steps []skyv1alpha1
 	return pod, nil
 }
 
+// MergePodTemplates returns the override pod template merged over the base one, like the pod
+// template of a task over the one of its workflow. Maps are merged key by key, the other fields set
+// on the override replace the ones of the base.
+func MergePodTemplates(base, override *skyv1alpha1.PodTemplate) *skyv1alpha1.PodTemplate {
+	if base == nil || override == nil {
+		if base == nil {
+			return override.DeepCopy()
+		}
+		return base.DeepCopy()
+	}
+
+	merged := base.DeepCopy()
+	override = override.DeepCopy()
+	merged.Labels = mergeStringMaps(merged.Labels, override.Labels)
+	merged.Annotations = mergeStringMaps(merged.Annotations, override.Annotations)
+	merged.NodeSelector = mergeStringMaps(merged.NodeSelector, override.NodeSelector)
+	if len(override.Tolerations) != 0 {
+		merged.Tolerations = override.Tolerations
+	}
+	if override.Affinity != nil {
+		merged.Affinity = override.Affinity
+	}
+	if override.ServiceAccountName != "" {
+		merged.ServiceAccountName = override.ServiceAccountName
+	}
+	if len(override.ImagePullSecrets) != 0 {
+		merged.ImagePullSecrets = override.ImagePullSecrets
+	}
+	if override.PriorityClassName != "" {
+		merged.PriorityClassName = override.PriorityClassName
+	}
+	if override.RuntimeClassName != nil {
+		merged.RuntimeClassName = override.RuntimeClassName
+	}
+	if len(override.HostAliases) != 0 {
+		merged.HostAliases = override.HostAliases
+	}
+	return merged
+}
+
+func mergeStringMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
+
+// applyPodTemplate configures a task Pod, without overriding the labels and annotations the
+// controller relies on.
+func applyPodTemplate(pod *v1.Pod, template *skyv1alpha1.PodTemplate) {
+	if template == nil {
+		return
+	}
+	for key, value := range template.Labels {
+		if _, ok := pod.Labels[key]; !ok {
+			pod.Labels[key] = value
+		}
+	}
+	for key, value := range template.Annotations {
+		if _, ok := pod.Annotations[key]; !ok {
+			pod.Annotations[key] = value
+		}
+	}
+	pod.Spec.NodeSelector = template.NodeSelector
+	pod.Spec.Tolerations = template.Tolerations
+	pod.Spec.Affinity = template.Affinity
+	pod.Spec.ServiceAccountName = template.ServiceAccountName
+	pod.Spec.ImagePullSecrets = template.ImagePullSecrets
+	pod.Spec.PriorityClassName = template.PriorityClassName
+	pod.Spec.RuntimeClassName = template.RuntimeClassName
+	pod.Spec.HostAliases = template.HostAliases
+}
+
 func initContainers(steps []skyv1alpha1.Step) []v1.Container {
 	scriptTemplate := "cp /app/entrypoint /app/bin;"
 	for index, step := range steps {
@@ -139,7 +258,7 @@ echo "%s" > ${scriptfile};
 
 	return []v1.Container{
 		{
-			Name:            "init-step",
+			Name:            InitContainerName,
 			Image:           "registry.cn-shanghai.aliyuncs.com/sky/entrypoint:v0.0.1",
 			ImagePullPolicy: v1.PullIfNotPresent,
 			Command:         []string{"sh"},
@@ -170,64 +289,131 @@ echo "%s" > ${scriptfile};
 	}
 }
 
-func stepContainers(steps []skyv1alpha1.Step, results string) ([]v1.Container, error) {
-	var containers []v1.Container
+// sidecarContainers returns the sidecars of a task as native sidecar containers: init containers
+// restarted on exit, which keep running next to the steps and are stopped by the kubelet once the
+// last step exited.
+func sidecarContainers(sidecars []v1.Container, replacer *strings.Replacer) []v1.Container {
+	always := v1.ContainerRestartPolicyAlways
+	containers := make([]v1.Container, 0, len(sidecars))
+	for _, sidecar := range sidecars {
+		container := *sidecar.DeepCopy()
+		container.RestartPolicy = &always
+		for i := range container.Args {
+			container.Args[i] = replacer.Replace(container.Args[i])
+		}
+		for i := range container.Env {
+			container.Env[i].Value = replacer.Replace(container.Env[i].Value)
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// isSidecar reports whether a container of a task Pod is a sidecar.
+func isSidecar(pod *v1.Pod, containerName string) bool {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == containerName {
+			return container.RestartPolicy != nil && *container.RestartPolicy == v1.ContainerRestartPolicyAlways
+		}
+	}
+	return false
+}
+
+// stepStages groups the indexes of the steps into the stages they run in, in order.
+func stepStages(steps []skyv1alpha1.Step) [][]int {
+	var stages [][]int
 	for index, step := range steps {
-		waitFile := fmt.Sprintf("%s/%d", downwardDir, index)
-		waitContent := fmt.Sprintf("%d", index)
-		if index != 0 {
-			waitFile = fmt.Sprintf("%s/%d", runDir, index-1)
-			waitContent = fmt.Sprintf("%d", index-1)
+		last := len(stages) - 1
+		if step.Stage != "" && last >= 0 && steps[stages[last][0]].Stage == step.Stage {
+			stages[last] = append(stages[last], index)
+			continue
 		}
-		postFile := fmt.Sprintf("%s/%d", runDir, index)
-
-		args := []string{
-			"--wait_file", waitFile,
-			"--wait_content", waitContent,
-			"--post_file", postFile,
-			"--post_content", fmt.Sprintf("%d", index),
-			"--command", fmt.Sprintf("%s/%s-%d", scriptDir, step.Name, index),
-			"--encode", fmt.Sprintf("%t", true),
-			"--results", results,
-			"--termination_message_path", terminationMessagePath,
-			"--params", step.Args,
+		stages = append(stages, []int{index})
+	}
+	return stages
+}
+
+func postFile(index int) string {
+	return fmt.Sprintf("%s/%d", runDir, index)
+}
+
+// stepContainers runs the stages one after the other: every step waits for the post files of the
+// steps of the previous stage, the first stage for the downward API file.
+func stepContainers(steps []skyv1alpha1.Step, results string, failurePolicy skyv1alpha1.StageFailurePolicy) ([]v1.Container, error) {
+	var containers []v1.Container
+	stages := stepStages(steps)
+	for stageIndex, stage := range stages {
+		for _, index := range stage {
+			containers = append(containers, stepContainer(steps[index], index, stageIndex, stages, results, failurePolicy))
 		}
-		containers = append(containers, v1.Container{
-			Name:                     step.Name,
-			Image:                    step.Image,
-			ImagePullPolicy:          v1.PullIfNotPresent,
-			Command:                  []string{"/app/bin/entrypoint"},
-			Args:                     args,
-			TerminationMessagePath:   terminationMessagePath,
-			TerminationMessagePolicy: v1.TerminationMessageReadFile,
-			VolumeMounts: []v1.VolumeMount{
-				{
-					Name:      entrypointVolumeName,
-					MountPath: "/app/bin",
-				},
-				{
-					Name:      scriptsVolumeName,
-					MountPath: scriptDir,
-				},
-				{
-					Name:      outputsVolumeName,
-					MountPath: outputDir,
-				},
-				{
-					Name:      downwardVolumeName,
-					MountPath: downwardDir,
-				},
-				{
-					Name:      runVolumeName,
-					MountPath: runDir,
-				},
-			},
-		})
 	}
 
 	return containers, nil
 }
 
+func stepContainer(step skyv1alpha1.Step, index, stageIndex int, stages [][]int, results string, failurePolicy skyv1alpha1.StageFailurePolicy) v1.Container {
+	var args []string
+	if stageIndex == 0 {
+		args = append(args, "--wait_file", fmt.Sprintf("%s/%d", downwardDir, 0), "--wait_content", "0")
+	} else {
+		for _, previous := range stages[stageIndex-1] {
+			args = append(args, "--wait_file", postFile(previous))
+		}
+	}
+	if failurePolicy == skyv1alpha1.StageFailFast {
+		for _, sibling := range stages[stageIndex] {
+			if sibling != index {
+				args = append(args, "--stop_file", postFile(sibling))
+			}
+		}
+	}
+
+	args = append(args,
+		"--post_file", postFile(index),
+		"--post_content", fmt.Sprintf("%d", index),
+		"--command", fmt.Sprintf("%s/%s-%d", scriptDir, step.Name, index),
+		"--encode", fmt.Sprintf("%t", true),
+		"--results", results,
+		"--termination_message_path", terminationMessagePath,
+		"--params", step.Args,
+	)
+	return v1.Container{
+		Name:                     step.Name,
+		Image:                    step.Image,
+		ImagePullPolicy:          v1.PullIfNotPresent,
+		Command:                  []string{"/app/bin/entrypoint"},
+		Args:                     args,
+		TerminationMessagePath:   terminationMessagePath,
+		TerminationMessagePolicy: v1.TerminationMessageReadFile,
+		Env:                      step.Env,
+		Resources:                step.Resources,
+		WorkingDir:               step.WorkingDir,
+		SecurityContext:          step.SecurityContext,
+		VolumeMounts: append([]v1.VolumeMount{
+			{
+				Name:      entrypointVolumeName,
+				MountPath: "/app/bin",
+			},
+			{
+				Name:      scriptsVolumeName,
+				MountPath: scriptDir,
+			},
+			{
+				Name:      outputsVolumeName,
+				MountPath: outputDir,
+			},
+			{
+				Name:      downwardVolumeName,
+				MountPath: downwardDir,
+			},
+			{
+				Name:      runVolumeName,
+				MountPath: runDir,
+			},
+		}, step.VolumeMounts...),
+	}
+}
+
 func encodeScript(script string) string {
 	return base64.StdEncoding.EncodeToString([]byte(script))
 }
@@ -0,0 +1,193 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Step containers", func() {
+	workflow := &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "default"},
+		Spec: skyv1alpha1.WorkflowSpec{
+			Inputs: []skyv1alpha1.Input{{Name: "target", Value: "linux"}},
+		},
+	}
+
+	It("should configure the container of every step", func() {
+		runAsNonRoot := true
+		token := v1.EnvVar{Name: "TOKEN", ValueFrom: &v1.EnvVarSource{SecretKeyRef: &v1.SecretKeySelector{
+			LocalObjectReference: v1.LocalObjectReference{Name: "registry"},
+			Key:                  "token",
+		}}}
+		resources := v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("2Gi")},
+			Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("4Gi")},
+		}
+		task := skyv1alpha1.Task{
+			Name: "test",
+			Volumes: []v1.Volume{{
+				Name:         "cache",
+				VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}},
+			}},
+			Steps: []skyv1alpha1.Step{{
+				Name:            "unit",
+				Image:           "golang",
+				Env:             []v1.EnvVar{{Name: "GOOS", Value: "{{inputs.target}}"}, token},
+				Resources:       resources,
+				WorkingDir:      "/src",
+				SecurityContext: &v1.SecurityContext{RunAsNonRoot: &runAsNonRoot},
+				VolumeMounts:    []v1.VolumeMount{{Name: "cache", MountPath: "/root/.cache"}},
+			}},
+		}
+
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		container := pod.Spec.Containers[0]
+		Expect(container.Env).To(Equal([]v1.EnvVar{{Name: "GOOS", Value: "linux"}, token}))
+		Expect(container.Resources).To(Equal(resources))
+		Expect(container.WorkingDir).To(Equal("/src"))
+		Expect(*container.SecurityContext.RunAsNonRoot).To(BeTrue())
+		Expect(container.VolumeMounts).To(ContainElement(v1.VolumeMount{Name: "cache", MountPath: "/root/.cache"}))
+		Expect(pod.Spec.Volumes).To(ContainElement(task.Volumes[0]))
+
+		// The placeholders are replaced in a copy, the workflow keeps its spec.
+		Expect(task.Steps[0].Env[0].Value).To(Equal("{{inputs.target}}"))
+	})
+
+	It("should merge the pod template of the task over the one of the workflow", func() {
+		gvisor := "gvisor"
+		workflow := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "default"},
+			Spec: skyv1alpha1.WorkflowSpec{
+				PodTemplate: &skyv1alpha1.PodTemplate{
+					Labels:             map[string]string{"team": "ci", workflowLabelKey: "other"},
+					NodeSelector:       map[string]string{"pool": "ci", "gpu": "false"},
+					Tolerations:        []v1.Toleration{{Key: "ci", Operator: v1.TolerationOpExists}},
+					ServiceAccountName: "builder",
+					ImagePullSecrets:   []v1.LocalObjectReference{{Name: "registry"}},
+				},
+			},
+		}
+		task := skyv1alpha1.Task{
+			Name: "test",
+			PodTemplate: &skyv1alpha1.PodTemplate{
+				Annotations:      map[string]string{"sidecar.istio.io/inject": "false"},
+				NodeSelector:     map[string]string{"pool": "large"},
+				RuntimeClassName: &gvisor,
+			},
+			Steps: []skyv1alpha1.Step{{Name: "unit", Image: "golang"}},
+		}
+
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).To(HaveKeyWithValue("team", "ci"))
+		Expect(pod.Labels).To(HaveKeyWithValue(workflowLabelKey, "build"))
+		Expect(pod.Annotations).To(HaveKeyWithValue("sidecar.istio.io/inject", "false"))
+		Expect(pod.Spec.NodeSelector).To(Equal(map[string]string{"pool": "large", "gpu": "false"}))
+		Expect(pod.Spec.Tolerations).To(Equal(workflow.Spec.PodTemplate.Tolerations))
+		Expect(pod.Spec.ServiceAccountName).To(Equal("builder"))
+		Expect(pod.Spec.ImagePullSecrets).To(Equal([]v1.LocalObjectReference{{Name: "registry"}}))
+		Expect(*pod.Spec.RuntimeClassName).To(Equal("gvisor"))
+		Expect(workflow.Spec.PodTemplate.NodeSelector).To(HaveKeyWithValue("pool", "ci"))
+	})
+
+	It("should start the sidecars before the steps and ignore them once stopped", func() {
+		task := skyv1alpha1.Task{
+			Name: "test",
+			Sidecars: []v1.Container{{
+				Name:  "postgres",
+				Image: "postgres:16",
+				Env:   []v1.EnvVar{{Name: "POSTGRES_DB", Value: "{{inputs.target}}"}},
+			}},
+			Steps: []skyv1alpha1.Step{{Name: "integration", Image: "golang"}},
+		}
+
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.InitContainers).To(HaveLen(2))
+		sidecar := pod.Spec.InitContainers[1]
+		Expect(sidecar.Name).To(Equal("postgres"))
+		Expect(*sidecar.RestartPolicy).To(Equal(v1.ContainerRestartPolicyAlways))
+		Expect(sidecar.Env).To(Equal([]v1.EnvVar{{Name: "POSTGRES_DB", Value: "linux"}}))
+		Expect(task.Sidecars[0].RestartPolicy).To(BeNil())
+		Expect(pod.Spec.Containers).To(HaveLen(1))
+
+		pod.Status.InitContainerStatuses = []v1.ContainerStatus{
+			{Name: "postgres", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 143}}},
+		}
+		pod.Status.ContainerStatuses = []v1.ContainerStatus{
+			{Name: "integration", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: 2}}},
+		}
+		reason, exitCode, message := podFailure(pod)
+		Expect(reason).To(Equal(skyv1alpha1.RetryReasonError))
+		Expect(*exitCode).To(Equal(int32(2)))
+		Expect(message).To(Equal("step integration exited with code 2"))
+	})
+
+	It("should run the steps of a stage together", func() {
+		task := skyv1alpha1.Task{
+			Name: "lint",
+			Steps: []skyv1alpha1.Step{
+				{Name: "checkout", Image: "git"},
+				{Name: "golangci", Image: "golangci", Stage: "lint"},
+				{Name: "hadolint", Image: "hadolint", Stage: "lint"},
+				{Name: "report", Image: "ubuntu"},
+			},
+		}
+		waitFiles := func(container v1.Container) (files, stopFiles []string) {
+			for i, arg := range container.Args {
+				switch arg {
+				case "--wait_file":
+					files = append(files, container.Args[i+1])
+				case "--stop_file":
+					stopFiles = append(stopFiles, container.Args[i+1])
+				}
+			}
+			return files, stopFiles
+		}
+
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-lint-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.Containers).To(HaveLen(4))
+		for i, expected := range []struct{ wait, stop []string }{
+			{wait: []string{"/tmp/sky/downward/0"}},
+			{wait: []string{"/tmp/sky/run/0"}, stop: []string{"/tmp/sky/run/2"}},
+			{wait: []string{"/tmp/sky/run/0"}, stop: []string{"/tmp/sky/run/1"}},
+			{wait: []string{"/tmp/sky/run/1", "/tmp/sky/run/2"}},
+		} {
+			wait, stop := waitFiles(pod.Spec.Containers[i])
+			Expect(wait).To(Equal(expected.wait))
+			Expect(stop).To(Equal(expected.stop))
+		}
+
+		task.StageFailurePolicy = skyv1alpha1.StageWaitAll
+		pod, err = generatePod(context.Background(), task, task.Steps, task.Name, "build-lint-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		_, stop := waitFiles(pod.Spec.Containers[1])
+		Expect(stop).To(BeEmpty())
+	})
+})
@@ -0,0 +1,125 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	podReasonEvicted          = "Evicted"
+	podReasonDeadlineExceeded = "DeadlineExceeded"
+	containerReasonOOMKilled  = "OOMKilled"
+)
+
+// podAttempt returns the attempt a task Pod was created for, the first attempt is 0.
+func podAttempt(pod *corev1.Pod) int {
+	attempt, err := strconv.Atoi(pod.Labels[taskAttemptLabelKey])
+	if err != nil {
+		return 0
+	}
+	return attempt
+}
+
+// podFailure explains why a task Pod failed. The exit code is only set when a container
+// terminated with a non-zero code.
+func podFailure(pod *corev1.Pod) (skyv1alpha1.RetryReason, *int32, string) {
+	switch pod.Status.Reason {
+	case podReasonEvicted:
+		return skyv1alpha1.RetryReasonEvicted, nil, pod.Status.Message
+	case podReasonDeadlineExceeded:
+		return skyv1alpha1.RetryReasonDeadlineExceeded, nil, pod.Status.Message
+	}
+
+	for _, containerStatus := range slices.Concat(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses) {
+		// Sidecars are killed once the steps exited, their exit code tells nothing about the task.
+		terminated := containerStatus.State.Terminated
+		if terminated == nil || terminated.ExitCode == 0 || isSidecar(pod, containerStatus.Name) {
+			continue
+		}
+		exitCode := terminated.ExitCode
+		if terminated.Reason == containerReasonOOMKilled {
+			return skyv1alpha1.RetryReasonOOMKilled, &exitCode, fmt.Sprintf("step %s was OOMKilled", containerStatus.Name)
+		}
+		return skyv1alpha1.RetryReasonError, &exitCode, fmt.Sprintf("step %s exited with code %d", containerStatus.Name, exitCode)
+	}
+
+	return skyv1alpha1.RetryReasonError, nil, pod.Status.Message
+}
+
+// shouldRetry reports whether a failed attempt is retried given the number of retries already made.
+func shouldRetry(strategy *skyv1alpha1.RetryStrategy, retries int, reason skyv1alpha1.RetryReason, exitCode *int32) bool {
+	if strategy == nil || int32(retries) >= strategy.Limit {
+		return false
+	}
+
+	if len(strategy.RetryOn) == 0 && len(strategy.ExitCodes) == 0 {
+		return true
+	}
+	if slices.Contains(strategy.RetryOn, reason) {
+		return true
+	}
+	return exitCode != nil && slices.Contains(strategy.ExitCodes, *exitCode)
+}
+
+// defaultRetryMaxBackoff caps the delay between two attempts of strategies without MaxDuration.
+const defaultRetryMaxBackoff = time.Hour
+
+// retryBackoff returns the delay before the given retry, the first retry is 0.
+func retryBackoff(strategy *skyv1alpha1.RetryStrategy, retry int) time.Duration {
+	if strategy == nil || strategy.Backoff == nil || strategy.Backoff.Duration == nil {
+		return 0
+	}
+
+	factor := time.Duration(2)
+	if strategy.Backoff.Factor != nil {
+		factor = time.Duration(*strategy.Backoff.Factor)
+	}
+	maxDelay := defaultRetryMaxBackoff
+	if strategy.Backoff.MaxDuration != nil {
+		maxDelay = strategy.Backoff.MaxDuration.Duration
+	}
+
+	delay := strategy.Backoff.Duration.Duration
+	for i := 0; i < retry && delay < maxDelay; i++ {
+		if factor > 1 && delay > maxDelay/factor {
+			// Multiplying again would exceed the cap, or overflow.
+			return maxDelay
+		}
+		delay *= factor
+	}
+	return min(delay, maxDelay)
+}
+
+// retryDelay returns how long a retrying task still has to wait before its next attempt.
+func retryDelay(strategy *skyv1alpha1.RetryStrategy, status skyv1alpha1.TaskStatus, now time.Time) time.Duration {
+	if len(status.Attempts) == 0 {
+		return 0
+	}
+
+	last := status.Attempts[len(status.Attempts)-1]
+	if last.CompletionTime == nil {
+		return 0
+	}
+	return last.CompletionTime.Add(retryBackoff(strategy, len(status.Attempts)-1)).Sub(now)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"math"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Retry", func() {
+	Context("When a task Pod failed", func() {
+		It("should detect evictions and OOMKilled steps", func() {
+			reason, exitCode, _ := podFailure(&corev1.Pod{Status: corev1.PodStatus{Reason: "Evicted"}})
+			Expect(reason).To(Equal(skyv1alpha1.RetryReasonEvicted))
+			Expect(exitCode).To(BeNil())
+
+			reason, exitCode, message := podFailure(&corev1.Pod{Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "build", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}},
+					{Name: "test", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 137, Reason: "OOMKilled"}}},
+				},
+			}})
+			Expect(reason).To(Equal(skyv1alpha1.RetryReasonOOMKilled))
+			Expect(*exitCode).To(BeEquivalentTo(137))
+			Expect(message).To(Equal("step test was OOMKilled"))
+		})
+
+		It("should only retry matching failures within the limit", func() {
+			strategy := &skyv1alpha1.RetryStrategy{
+				Limit:     2,
+				RetryOn:   []skyv1alpha1.RetryReason{skyv1alpha1.RetryReasonEvicted},
+				ExitCodes: []int32{3},
+			}
+			three, one := int32(3), int32(1)
+
+			Expect(shouldRetry(strategy, 0, skyv1alpha1.RetryReasonEvicted, nil)).To(BeTrue())
+			Expect(shouldRetry(strategy, 1, skyv1alpha1.RetryReasonError, &three)).To(BeTrue())
+			Expect(shouldRetry(strategy, 1, skyv1alpha1.RetryReasonError, &one)).To(BeFalse())
+			Expect(shouldRetry(strategy, 2, skyv1alpha1.RetryReasonEvicted, nil)).To(BeFalse())
+			Expect(shouldRetry(&skyv1alpha1.RetryStrategy{Limit: 1}, 0, skyv1alpha1.RetryReasonError, &one)).To(BeTrue())
+			Expect(shouldRetry(nil, 0, skyv1alpha1.RetryReasonError, &one)).To(BeFalse())
+		})
+
+		It("should back off exponentially up to the maximum", func() {
+			factor := int32(3)
+			strategy := &skyv1alpha1.RetryStrategy{
+				Limit: 5,
+				Backoff: &skyv1alpha1.RetryBackoff{
+					Duration:    &metav1.Duration{Duration: 10 * time.Second},
+					Factor:      &factor,
+					MaxDuration: &metav1.Duration{Duration: time.Minute},
+				},
+			}
+
+			Expect(retryBackoff(strategy, 0)).To(Equal(10 * time.Second))
+			Expect(retryBackoff(strategy, 1)).To(Equal(30 * time.Second))
+			Expect(retryBackoff(strategy, 2)).To(Equal(time.Minute))
+			Expect(retryBackoff(&skyv1alpha1.RetryStrategy{Limit: 1}, 3)).To(BeZero())
+		})
+
+		It("should cap the delay of strategies without maximum", func() {
+			factor := int32(10)
+			strategy := &skyv1alpha1.RetryStrategy{Limit: 1000, Backoff: &skyv1alpha1.RetryBackoff{
+				Duration: &metav1.Duration{Duration: time.Second},
+				Factor:   &factor,
+			}}
+			Expect(retryBackoff(strategy, 2)).To(Equal(100 * time.Second))
+			Expect(retryBackoff(strategy, 4)).To(Equal(time.Hour))
+			Expect(retryBackoff(strategy, 999)).To(Equal(time.Hour))
+
+			factor = math.MaxInt32
+			strategy.Backoff.Duration = &metav1.Duration{Duration: 1000 * time.Hour}
+			strategy.Backoff.MaxDuration = &metav1.Duration{Duration: math.MaxInt64}
+			Expect(retryBackoff(strategy, 999)).To(Equal(time.Duration(math.MaxInt64)))
+		})
+	})
+})
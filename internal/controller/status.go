@@ -0,0 +1,162 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// taskPhase returns the phase of a task running a Pod in the given phase. A Pod whose node stopped
+// reporting is still considered running.
+func taskPhase(phase v1.PodPhase) skyv1alpha1.TaskPhase {
+	switch phase {
+	case v1.PodSucceeded:
+		return skyv1alpha1.TaskStatusSucceeded
+	case v1.PodFailed:
+		return skyv1alpha1.TaskStatusFailed
+	case v1.PodRunning, v1.PodUnknown:
+		return skyv1alpha1.TaskStatusRunning
+	}
+	return skyv1alpha1.TaskStatusPending
+}
+
+// stepStatuses returns the states of the step containers of a Pod, in step order. The steps still
+// running when the Pod exceeded its deadline get the DeadlineExceeded reason.
+func stepStatuses(_pod *v1.Pod) []skyv1alpha1.StepStatus {
+	containerStatuses := make(map[string]v1.ContainerStatus, len(_pod.Status.ContainerStatuses))
+	for _, containerStatus := range _pod.Status.ContainerStatuses {
+		containerStatuses[containerStatus.Name] = containerStatus
+	}
+
+	steps := make([]skyv1alpha1.StepStatus, 0, len(_pod.Spec.Containers))
+	for _, container := range _pod.Spec.Containers {
+		step := skyv1alpha1.StepStatus{Name: container.Name, State: skyv1alpha1.StepStateWaiting}
+		state := containerStatuses[container.Name].State
+		switch {
+		case state.Terminated != nil:
+			terminated := state.Terminated.DeepCopy()
+			step.State = skyv1alpha1.StepStateTerminated
+			step.Reason = terminated.Reason
+			step.ExitCode = &terminated.ExitCode
+			step.StartTime = &terminated.StartedAt
+			step.CompletionTime = &terminated.FinishedAt
+		case state.Running != nil:
+			step.State = skyv1alpha1.StepStateRunning
+			step.StartTime = state.Running.StartedAt.DeepCopy()
+		case state.Waiting != nil:
+			step.Reason = state.Waiting.Reason
+		}
+		if _pod.Status.Reason == podReasonDeadlineExceeded && (step.ExitCode == nil || *step.ExitCode != 0) {
+			step.Reason = podReasonDeadlineExceeded
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// setConditions derives the conditions of a workflow from its status. notReadyReason is set when
+// the controller cannot run the workflow.
+func setConditions(workflow *skyv1alpha1.Workflow, notReadyReason string) {
+	status := workflow.Status.Status
+	reason := skyv1alpha1.WorkflowReasonRunning
+	switch status {
+	case skyv1alpha1.WorkFlowStatusWaiting, "":
+		reason = skyv1alpha1.WorkflowReasonWaiting
+	case skyv1alpha1.WorkFlowStatusPause:
+		reason = skyv1alpha1.WorkflowReasonSuspended
+	case skyv1alpha1.WorkFlowStatusSuccess:
+		reason = skyv1alpha1.WorkflowReasonSucceeded
+	case skyv1alpha1.WorkFlowStatusFailed:
+		reason = skyv1alpha1.WorkflowReasonFailed
+	case skyv1alpha1.WorkFlowStatusCancel:
+		reason = skyv1alpha1.WorkflowReasonCancelled
+	}
+	completed := status == skyv1alpha1.WorkFlowStatusSuccess || status == skyv1alpha1.WorkFlowStatusFailed || status == skyv1alpha1.WorkFlowStatusCancel
+
+	setCondition := func(conditionType string, conditionStatus metav1.ConditionStatus, conditionReason string) {
+		meta.SetStatusCondition(&workflow.Status.Conditions, metav1.Condition{
+			Type:               conditionType,
+			Status:             conditionStatus,
+			Reason:             conditionReason,
+			Message:            workflow.Status.Message,
+			ObservedGeneration: workflow.Generation,
+		})
+	}
+
+	if notReadyReason != "" {
+		setCondition(skyv1alpha1.WorkflowConditionReady, metav1.ConditionFalse, notReadyReason)
+	} else {
+		setCondition(skyv1alpha1.WorkflowConditionReady, metav1.ConditionTrue, skyv1alpha1.WorkflowReasonReconciled)
+	}
+
+	switch {
+	case status == skyv1alpha1.WorkFlowStatusSuccess:
+		setCondition(skyv1alpha1.WorkflowConditionSucceeded, metav1.ConditionTrue, reason)
+	case completed:
+		setCondition(skyv1alpha1.WorkflowConditionSucceeded, metav1.ConditionFalse, reason)
+	default:
+		setCondition(skyv1alpha1.WorkflowConditionSucceeded, metav1.ConditionUnknown, reason)
+	}
+	failed := metav1.ConditionFalse
+	if completed && status != skyv1alpha1.WorkFlowStatusSuccess {
+		failed = metav1.ConditionTrue
+	}
+	setCondition(skyv1alpha1.WorkflowConditionFailed, failed, reason)
+	suspended := metav1.ConditionFalse
+	if status == skyv1alpha1.WorkFlowStatusPause {
+		suspended = metav1.ConditionTrue
+	}
+	setCondition(skyv1alpha1.WorkflowConditionSuspended, suspended, reason)
+}
+
+// setProgress counts the completed tasks of a workflow, instances count through their fanned-out
+// task, and records how long the workflow ran once it completed.
+func setProgress(workflow *skyv1alpha1.Workflow) {
+	var total, completed int32
+	for _, task := range slices.Concat(workflow.GetTasks(), workflow.GetFinally()) {
+		total++
+		if status, ok := workflow.Status.TaskStatus[task.Name]; ok && isTaskCompleted(status.Status) {
+			completed++
+		}
+	}
+	workflow.Status.TotalTasks = total
+	workflow.Status.CompletedTasks = completed
+	workflow.Status.Progress = fmt.Sprintf("%d/%d", completed, total)
+
+	workflow.Status.Duration = ""
+	if workflow.Status.StartTime != nil && workflow.Status.CompletionTime != nil {
+		workflow.Status.Duration = duration.HumanDuration(workflow.Status.CompletionTime.Sub(workflow.Status.StartTime.Time))
+	}
+}
+
+// updateStatus writes the status of a workflow with its conditions, without the outputs stored in
+// its ConfigMap.
+func (r *WorkflowReconciler) updateStatus(ctx context.Context, workflow *skyv1alpha1.Workflow, notReadyReason string) error {
+	workflow.Status.ObservedGeneration = workflow.Generation
+	setConditions(workflow, notReadyReason)
+	setProgress(workflow)
+	clearOffloadedOutputs(workflow)
+	return r.Status().Update(ctx, workflow)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Status", func() {
+	started := metav1.NewTime(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+	finished := metav1.NewTime(started.Add(time.Minute))
+
+	It("should record the state of every step", func() {
+		_pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "build-x1", CreationTimestamp: started},
+			Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "compile"}, {Name: "test"}, {Name: "package"}}},
+			Status: v1.PodStatus{
+				Phase: v1.PodRunning,
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: "package", State: v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "PodInitializing"}}},
+					{Name: "test", State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: finished}}},
+					{Name: "compile", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{
+						Reason: "Completed", StartedAt: started, FinishedAt: finished,
+					}}},
+				},
+			},
+		}
+
+		status := podTaskStatus(context.Background(), "build", _pod)
+		Expect(status.Status).To(Equal(skyv1alpha1.TaskStatusRunning))
+		Expect(status.StartTime).To(Equal(&started))
+		Expect(status.Steps).To(Equal([]skyv1alpha1.StepStatus{
+			{Name: "compile", State: skyv1alpha1.StepStateTerminated, Reason: "Completed", ExitCode: new(int32), StartTime: &started, CompletionTime: &finished},
+			{Name: "test", State: skyv1alpha1.StepStateRunning, StartTime: &finished},
+			{Name: "package", State: skyv1alpha1.StepStateWaiting, Reason: "PodInitializing"},
+		}))
+
+		_pod.Status.Phase = v1.PodFailed
+		_pod.Status.Reason = "DeadlineExceeded"
+		steps := stepStatuses(_pod)
+		Expect(steps[0].Reason).To(Equal("Completed"))
+		Expect(steps[1].Reason).To(Equal("DeadlineExceeded"))
+		Expect(steps[2].Reason).To(Equal("DeadlineExceeded"))
+
+		Expect(taskPhase(v1.PodUnknown)).To(Equal(skyv1alpha1.TaskStatusRunning))
+		Expect(taskPhase("")).To(Equal(skyv1alpha1.TaskStatusPending))
+	})
+
+	It("should derive the conditions from the status", func() {
+		workflow := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Generation: 2}}
+		conditionStatus := func(conditionType string) metav1.ConditionStatus {
+			return meta.FindStatusCondition(workflow.Status.Conditions, conditionType).Status
+		}
+
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusPause
+		setConditions(workflow, "")
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionReady)).To(Equal(metav1.ConditionTrue))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionSucceeded)).To(Equal(metav1.ConditionUnknown))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionFailed)).To(Equal(metav1.ConditionFalse))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionSuspended)).To(Equal(metav1.ConditionTrue))
+		Expect(meta.FindStatusCondition(workflow.Status.Conditions, skyv1alpha1.WorkflowConditionSuspended).ObservedGeneration).To(Equal(int64(2)))
+
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusSuccess
+		setConditions(workflow, "")
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionSucceeded)).To(Equal(metav1.ConditionTrue))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionSuspended)).To(Equal(metav1.ConditionFalse))
+
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+		workflow.Status.Message = "WorkFlow has duplicate task names"
+		setConditions(workflow, skyv1alpha1.WorkflowReasonInvalidSpec)
+		ready := meta.FindStatusCondition(workflow.Status.Conditions, skyv1alpha1.WorkflowConditionReady)
+		Expect(ready.Status).To(Equal(metav1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(skyv1alpha1.WorkflowReasonInvalidSpec))
+		Expect(ready.Message).To(Equal("WorkFlow has duplicate task names"))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionSucceeded)).To(Equal(metav1.ConditionFalse))
+		Expect(conditionStatus(skyv1alpha1.WorkflowConditionFailed)).To(Equal(metav1.ConditionTrue))
+	})
+
+	It("should count the completed tasks", func() {
+		workflow := &skyv1alpha1.Workflow{
+			Spec: skyv1alpha1.WorkflowSpec{
+				Tasks:   []skyv1alpha1.Task{{Name: "build"}, {Name: "test", WithItems: []string{"a", "b"}}, {Name: "deploy"}},
+				Finally: []skyv1alpha1.Task{{Name: "notify"}},
+			},
+			Status: skyv1alpha1.WorkflowStatus{
+				StartTime: &started,
+				TaskStatus: map[string]skyv1alpha1.TaskStatus{
+					"build":  {Name: "build", Status: skyv1alpha1.TaskStatusSucceeded},
+					"test":   {Name: "test", Status: skyv1alpha1.TaskStatusRunning, Instances: []string{"test-0", "test-1"}},
+					"test-0": {Name: "test-0", Parent: "test", Status: skyv1alpha1.TaskStatusSucceeded},
+					"test-1": {Name: "test-1", Parent: "test", Status: skyv1alpha1.TaskStatusRunning},
+				},
+			},
+		}
+
+		setProgress(workflow)
+		Expect(workflow.Status.TotalTasks).To(Equal(int32(4)))
+		Expect(workflow.Status.CompletedTasks).To(Equal(int32(1)))
+		Expect(workflow.Status.Progress).To(Equal("1/4"))
+		Expect(workflow.Status.Duration).To(BeEmpty())
+
+		workflow.Status.TaskStatus["test"] = skyv1alpha1.TaskStatus{Name: "test", Status: skyv1alpha1.TaskStatusFailed}
+		workflow.Status.TaskStatus["deploy"] = skyv1alpha1.TaskStatus{Name: "deploy", Status: skyv1alpha1.TaskStatusSkipped}
+		workflow.Status.TaskStatus["notify"] = skyv1alpha1.TaskStatus{Name: "notify", Status: skyv1alpha1.TaskStatusSucceeded}
+		workflow.Status.CompletionTime = &finished
+		setProgress(workflow)
+		Expect(workflow.Status.Progress).To(Equal("4/4"))
+		Expect(workflow.Status.Duration).To(Equal("60s"))
+	})
+})
@@ -0,0 +1,197 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// parentWorkflowLabelKey labels the child workflows with the name of their parent.
+const parentWorkflowLabelKey = "parent_workflow_name"
+
+// childWorkflowName names the child workflow of a task.
+func childWorkflowName(workflowName, taskName string) string {
+	return fmt.Sprintf("%s-%s", workflowName, taskName)
+}
+
+// newChildWorkflow returns the child workflow of a task, controlled by the workflow so that it is
+// deleted with it. The inputs of the task override the ones of the child.
+func newChildWorkflow(task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow) (*skyv1alpha1.Workflow, error) {
+	if task.Workflow.Spec == nil && task.Workflow.WorkflowTemplateRef == nil {
+		return nil, fmt.Errorf("task %s runs a workflow without spec or template", task.Name)
+	}
+
+	spec := skyv1alpha1.WorkflowSpec{}
+	if task.Workflow.Spec != nil {
+		spec = *task.Workflow.Spec.DeepCopy()
+	}
+	if task.Workflow.WorkflowTemplateRef != nil {
+		spec.WorkflowTemplateRef = task.Workflow.WorkflowTemplateRef.DeepCopy()
+	}
+	replacer := strings.NewReplacer(workflowReplacements(workFlow)...)
+	for _, input := range task.Workflow.Inputs {
+		input.Value = replacer.Replace(input.Value)
+		overridden := false
+		for i := range spec.Inputs {
+			if spec.Inputs[i].Name == input.Name {
+				spec.Inputs[i].Value = input.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			spec.Inputs = append(spec.Inputs, input)
+		}
+	}
+
+	return &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      childWorkflowName(workFlow.Name, task.Name),
+			Namespace: workFlow.Namespace,
+			Labels: map[string]string{
+				parentWorkflowLabelKey: workFlow.Name,
+				taskLabelKey:           task.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(workFlow, schema.GroupVersionKind{
+					Kind:    skyv1alpha1.KindName,
+					Group:   skyv1alpha1.GroupVersion.Group,
+					Version: skyv1alpha1.GroupVersion.Version,
+				}),
+			},
+		},
+		Spec: spec,
+	}, nil
+}
+
+// childTaskStatus converts the observed state of a child workflow into a TaskStatus.
+func childTaskStatus(taskName string, child *skyv1alpha1.Workflow) skyv1alpha1.TaskStatus {
+	status := skyv1alpha1.TaskStatus{
+		Name:         taskName,
+		WorkflowName: child.Name,
+		Message:      child.Status.Message,
+	}
+	if !child.CreationTimestamp.IsZero() {
+		status.StartTime = child.CreationTimestamp.DeepCopy()
+	}
+	switch child.Status.Status {
+	case skyv1alpha1.WorkFlowStatusSuccess:
+		status.Status = skyv1alpha1.TaskStatusSucceeded
+		for _, output := range child.Status.Outputs {
+			status.Outputs = append(status.Outputs, &skyv1alpha1.Output{Name: output.Name, Value: output.Value})
+		}
+	case skyv1alpha1.WorkFlowStatusFailed, skyv1alpha1.WorkFlowStatusCancel:
+		status.Status = skyv1alpha1.TaskStatusFailed
+		status.Message = fmt.Sprintf("workflow %s finished with status %s", child.Name, child.Status.Status)
+		if child.Status.Message != "" {
+			status.Message = fmt.Sprintf("%s: %s", status.Message, child.Status.Message)
+		}
+	case "":
+		status.Status = skyv1alpha1.TaskStatusPending
+	default:
+		status.Status = skyv1alpha1.TaskStatusRunning
+	}
+	if isTaskCompleted(status.Status) {
+		status.CompletionTime = child.Status.CompletionTime
+		if status.CompletionTime == nil {
+			now := metav1.Now()
+			status.CompletionTime = &now
+		}
+	}
+	return status
+}
+
+// listChildWorkflows returns the child workflows controlled by the workflow, keyed by task name.
+func (r *WorkflowReconciler) listChildWorkflows(ctx context.Context, workflow *skyv1alpha1.Workflow) (map[string]*skyv1alpha1.Workflow, error) {
+	children := &skyv1alpha1.WorkflowList{}
+	if err := r.Client.List(ctx, children,
+		client.InNamespace(workflow.GetNamespace()),
+		client.MatchingLabels{parentWorkflowLabelKey: workflow.GetName()},
+	); err != nil {
+		return nil, err
+	}
+
+	taskChildren := make(map[string]*skyv1alpha1.Workflow, len(children.Items))
+	for i := range children.Items {
+		child := &children.Items[i]
+		if metav1.IsControlledBy(child, workflow) {
+			taskChildren[child.Labels[taskLabelKey]] = child
+		}
+	}
+	return taskChildren, nil
+}
+
+// createChildWorkflow creates the child workflow of a task. A child with the same name that was
+// already created by the workflow is adopted, such as one the cache has not seen yet. A workflow
+// with the same name not controlled by the workflow is not used: the reason is returned instead.
+func (r *WorkflowReconciler) createChildWorkflow(ctx context.Context, task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow) (*skyv1alpha1.Workflow, string, error) {
+	child, err := newChildWorkflow(task, workFlow)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := r.Client.Create(ctx, child); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return nil, "", err
+		}
+		existing := &skyv1alpha1.Workflow{}
+		if err := r.APIReader.Get(ctx, client.ObjectKeyFromObject(child), existing); err != nil {
+			return nil, "", err
+		}
+		if !metav1.IsControlledBy(existing, workFlow) || existing.Labels[taskLabelKey] != task.Name {
+			return nil, fmt.Sprintf("workflow %s of task %s already exists and is not controlled by the workflow", child.Name, task.Name), nil
+		}
+		return existing, "", nil
+	}
+	return child, "", nil
+}
+
+// cancelChildWorkflow cancels a child workflow, which still runs its finally tasks.
+func (r *WorkflowReconciler) cancelChildWorkflow(ctx context.Context, workflow *skyv1alpha1.Workflow, name string) error {
+	logger := log.FromContext(ctx)
+
+	child := &skyv1alpha1.Workflow{}
+	if err := r.Client.Get(ctx, client.ObjectKey{Namespace: workflow.Namespace, Name: name}, child); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if child.Spec.Cancel {
+		return nil
+	}
+	child.Spec.Cancel = true
+	if err := r.Client.Update(ctx, child); err != nil {
+		return err
+	}
+	logger.Info("Cancelled child WorkFlow", "workflow", name)
+	return nil
+}
+
+// workflowOutputs resolves the outputs of a completed workflow from the outputs of its tasks.
+func workflowOutputs(workFlow *skyv1alpha1.Workflow) []*skyv1alpha1.Output {
+	replacer := strings.NewReplacer(workflowReplacements(workFlow)...)
+	var outputs []*skyv1alpha1.Output
+	for _, output := range workFlow.GetOutputs() {
+		outputs = append(outputs, &skyv1alpha1.Output{Name: output.Name, Value: replacer.Replace(output.Value)})
+	}
+	return outputs
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Sub-workflows", func() {
+	workflow := &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "monorepo", Namespace: "default", UID: "1234"},
+		Spec: skyv1alpha1.WorkflowSpec{
+			Inputs: []skyv1alpha1.Input{{Name: "revision", Value: "abc"}},
+		},
+		Status: skyv1alpha1.WorkflowStatus{
+			TaskStatus: map[string]skyv1alpha1.TaskStatus{
+				"changes": {Name: "changes", Status: skyv1alpha1.TaskStatusSucceeded, Outputs: []*skyv1alpha1.Output{{Name: "services", Value: "api"}}},
+			},
+		},
+	}
+
+	It("should create the child with the mapped inputs", func() {
+		task := skyv1alpha1.Task{
+			Name: "api",
+			Workflow: &skyv1alpha1.SubWorkflow{
+				WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "service"},
+				Spec: &skyv1alpha1.WorkflowSpec{
+					Inputs: []skyv1alpha1.Input{{Name: "revision", Value: "main"}, {Name: "lint", Value: "true"}},
+				},
+				Inputs: []skyv1alpha1.Input{
+					{Name: "revision", Value: "{{inputs.revision}}"},
+					{Name: "service", Value: "{{tasks.changes.outputs.services}}"},
+				},
+			},
+		}
+
+		child, err := newChildWorkflow(task, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(child.Name).To(Equal("monorepo-api"))
+		Expect(child.Labels).To(Equal(map[string]string{parentWorkflowLabelKey: "monorepo", taskLabelKey: "api"}))
+		Expect(metav1.IsControlledBy(child, workflow)).To(BeTrue())
+		Expect(child.Spec.WorkflowTemplateRef.Name).To(Equal("service"))
+		Expect(child.Spec.Inputs).To(Equal([]skyv1alpha1.Input{
+			{Name: "revision", Value: "abc"},
+			{Name: "lint", Value: "true"},
+			{Name: "service", Value: "api"},
+		}))
+		Expect(task.Workflow.Spec.Inputs[0].Value).To(Equal("main"))
+
+		_, err = newChildWorkflow(skyv1alpha1.Task{Name: "empty", Workflow: &skyv1alpha1.SubWorkflow{}}, workflow)
+		Expect(err).To(MatchError("task empty runs a workflow without spec or template"))
+	})
+
+	It("should complete the task with the child", func() {
+		child := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "monorepo-api"}}
+		Expect(childTaskStatus("api", child).Status).To(Equal(skyv1alpha1.TaskStatusPending))
+
+		child.Status.Status = skyv1alpha1.WorkFlowStatusPause
+		Expect(childTaskStatus("api", child).Status).To(Equal(skyv1alpha1.TaskStatusRunning))
+
+		child.Status.Status = skyv1alpha1.WorkFlowStatusSuccess
+		child.Status.Outputs = []*skyv1alpha1.Output{{Name: "image", Value: "registry/api:abc"}}
+		status := childTaskStatus("api", child)
+		Expect(status.Status).To(Equal(skyv1alpha1.TaskStatusSucceeded))
+		Expect(status.WorkflowName).To(Equal("monorepo-api"))
+		Expect(status.Outputs).To(Equal([]*skyv1alpha1.Output{{Name: "image", Value: "registry/api:abc"}}))
+		Expect(status.CompletionTime).NotTo(BeNil())
+
+		child.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+		child.Status.Message = "WorkFlow has duplicate task names"
+		status = childTaskStatus("api", child)
+		Expect(status.Status).To(Equal(skyv1alpha1.TaskStatusFailed))
+		Expect(status.Message).To(Equal("workflow monorepo-api finished with status Failed: WorkFlow has duplicate task names"))
+	})
+
+	It("should adopt an existing child and not use the workflows of others", func() {
+		testScheme := runtime.NewScheme()
+		Expect(clientgoscheme.AddToScheme(testScheme)).To(Succeed())
+		Expect(skyv1alpha1.AddToScheme(testScheme)).To(Succeed())
+
+		task := skyv1alpha1.Task{Name: "api", Workflow: &skyv1alpha1.SubWorkflow{WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "service"}}}
+		existing, err := newChildWorkflow(task, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		taken := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "monorepo-api", Namespace: "default"}}
+		ctx := context.Background()
+
+		c := fake.NewClientBuilder().WithScheme(testScheme).WithObjects(existing).Build()
+		r := &WorkflowReconciler{Client: c, APIReader: c}
+		child, conflict, err := r.createChildWorkflow(ctx, task, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflict).To(BeEmpty())
+		Expect(child.Name).To(Equal("monorepo-api"))
+
+		c = fake.NewClientBuilder().WithScheme(testScheme).WithObjects(taken).Build()
+		r = &WorkflowReconciler{Client: c, APIReader: c}
+		child, conflict, err = r.createChildWorkflow(ctx, task, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(child).To(BeNil())
+		Expect(conflict).To(Equal("workflow monorepo-api of task api already exists and is not controlled by the workflow"))
+	})
+
+	It("should resolve the outputs of the workflow", func() {
+		workflow := workflow.DeepCopy()
+		workflow.Spec.Outputs = []skyv1alpha1.WorkflowOutput{{Name: "services", Value: "{{tasks.changes.outputs.services}}@{{inputs.revision}}"}}
+		Expect(workflowOutputs(workflow)).To(Equal([]*skyv1alpha1.Output{{Name: "services", Value: "api@abc"}}))
+	})
+})
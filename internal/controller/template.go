@@ -0,0 +1,219 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// resolveTemplates snapshots the inputs and tasks of a workflow referencing templates into its
+// status, once. The workflow keeps running the snapshot when the templates change afterwards.
+func (r *WorkflowReconciler) resolveTemplates(ctx context.Context, workflow *skyv1alpha1.Workflow) error {
+	if workflow.Status.StoredSpec != nil || !workflow.UsesTemplates() {
+		return nil
+	}
+
+	templates := map[skyv1alpha1.TemplateRef]*skyv1alpha1.WorkflowTemplateSpec{}
+	getTemplate := func(ref skyv1alpha1.TemplateRef) (*skyv1alpha1.WorkflowTemplateSpec, error) {
+		ref.Kind = ref.GetKind()
+		if spec, ok := templates[ref]; ok {
+			return spec, nil
+		}
+		spec, err := r.getTemplate(ctx, workflow.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		templates[ref] = spec
+		return spec, nil
+	}
+
+	spec, err := resolveWorkflowSpec(workflow.Spec, getTemplate)
+	if err != nil {
+		return err
+	}
+	workflow.Status.StoredSpec = spec
+	return nil
+}
+
+func (r *WorkflowReconciler) getTemplate(ctx context.Context, namespace string, ref skyv1alpha1.TemplateRef) (*skyv1alpha1.WorkflowTemplateSpec, error) {
+	if ref.GetKind() == skyv1alpha1.TemplateKindClusterWorkflowTemplate {
+		template := &skyv1alpha1.ClusterWorkflowTemplate{}
+		if err := r.Get(ctx, types.NamespacedName{Name: ref.Name}, template); err != nil {
+			return nil, fmt.Errorf("failed to get %s %s: %v", ref.GetKind(), ref.Name, err)
+		}
+		return &template.Spec, nil
+	}
+
+	template := &skyv1alpha1.WorkflowTemplate{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, template); err != nil {
+		return nil, fmt.Errorf("failed to get %s %s: %v", ref.GetKind(), ref.Name, err)
+	}
+	return &template.Spec, nil
+}
+
+// resolveWorkflowSpec returns the inputs and tasks of a workflow with its templates inlined: the
+// workflow template tasks followed by the workflow tasks, finally tasks alike, each task template
+// merged into the task referencing it.
+func resolveWorkflowSpec(spec skyv1alpha1.WorkflowSpec, getTemplate func(skyv1alpha1.TemplateRef) (*skyv1alpha1.WorkflowTemplateSpec, error)) (*skyv1alpha1.WorkflowSpec, error) {
+	resolved := &skyv1alpha1.WorkflowSpec{}
+	if spec.WorkflowTemplateRef != nil {
+		template, err := getTemplate(*spec.WorkflowTemplateRef)
+		if err != nil {
+			return nil, err
+		}
+		resolved.Inputs = append(resolved.Inputs, template.Inputs...)
+		resolved.Tasks = append(resolved.Tasks, template.Tasks...)
+		resolved.Finally = append(resolved.Finally, template.Finally...)
+		resolved.Workspaces = append(resolved.Workspaces, template.Workspaces...)
+		resolved.Outputs = append(resolved.Outputs, template.Outputs...)
+		resolved.PodTemplate = template.PodTemplate
+	}
+	resolved = resolved.DeepCopy()
+	resolved.PodTemplate = MergePodTemplates(resolved.PodTemplate, spec.PodTemplate)
+
+	for _, input := range spec.Inputs {
+		overridden := false
+		for i := range resolved.Inputs {
+			if resolved.Inputs[i].Name == input.Name {
+				resolved.Inputs[i].Value = input.Value
+				overridden = true
+			}
+		}
+		if !overridden {
+			resolved.Inputs = append(resolved.Inputs, input)
+		}
+	}
+	for _, workspace := range spec.Workspaces {
+		index := slices.IndexFunc(resolved.Workspaces, func(w skyv1alpha1.Workspace) bool { return w.Name == workspace.Name })
+		if index < 0 {
+			resolved.Workspaces = append(resolved.Workspaces, *workspace.DeepCopy())
+			continue
+		}
+		resolved.Workspaces[index] = *workspace.DeepCopy()
+	}
+	for _, output := range spec.Outputs {
+		index := slices.IndexFunc(resolved.Outputs, func(o skyv1alpha1.WorkflowOutput) bool { return o.Name == output.Name })
+		if index < 0 {
+			resolved.Outputs = append(resolved.Outputs, output)
+			continue
+		}
+		resolved.Outputs[index] = output
+	}
+	for _, task := range spec.Tasks {
+		resolved.Tasks = append(resolved.Tasks, *task.DeepCopy())
+	}
+	for _, task := range spec.Finally {
+		resolved.Finally = append(resolved.Finally, *task.DeepCopy())
+	}
+
+	var err error
+	if resolved.Tasks, err = resolveTaskTemplates(resolved.Tasks, getTemplate); err != nil {
+		return nil, err
+	}
+	if resolved.Finally, err = resolveTaskTemplates(resolved.Finally, getTemplate); err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// resolveTaskTemplates merges the task templates into the tasks referencing them.
+func resolveTaskTemplates(tasks []skyv1alpha1.Task, getTemplate func(skyv1alpha1.TemplateRef) (*skyv1alpha1.WorkflowTemplateSpec, error)) ([]skyv1alpha1.Task, error) {
+	for i, task := range tasks {
+		if task.TemplateRef == nil {
+			continue
+		}
+		template, err := getTemplate(task.TemplateRef.TemplateRef)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: %v", task.Name, err)
+		}
+		var templateTask *skyv1alpha1.Task
+		for _, candidate := range slices.Concat(template.Tasks, template.Finally) {
+			if candidate.Name == task.TemplateRef.Task {
+				templateTask = &candidate
+			}
+		}
+		if templateTask == nil {
+			return nil, fmt.Errorf("task %s: %s %s has no task %s", task.Name, task.TemplateRef.GetKind(), task.TemplateRef.Name, task.TemplateRef.Task)
+		}
+		if templateTask.TemplateRef != nil {
+			return nil, fmt.Errorf("task %s: task %s of %s %s references another template", task.Name, templateTask.Name, task.TemplateRef.GetKind(), task.TemplateRef.Name)
+		}
+		tasks[i] = mergeTaskTemplate(*templateTask.DeepCopy(), task)
+	}
+	return tasks, nil
+}
+
+// mergeTaskTemplate overrides the template task with the fields set on the task referencing it. The
+// dependencies and conditions always come from the referencing task, they depend on the workflow.
+func mergeTaskTemplate(template, task skyv1alpha1.Task) skyv1alpha1.Task {
+	template.Name = task.Name
+	template.Dependencies = task.Dependencies
+	template.DependencyPolicy = task.DependencyPolicy
+	template.When = task.When
+	template.TemplateRef = nil
+	if task.DisplayName != "" {
+		template.DisplayName = task.DisplayName
+	}
+	if task.Description != "" {
+		template.Description = task.Description
+	}
+	if len(task.WithItems) != 0 || task.WithParam != "" || task.Matrix != nil {
+		template.WithItems = task.WithItems
+		template.WithParam = task.WithParam
+		template.Matrix = task.Matrix
+	}
+	if len(task.Outputs) != 0 {
+		template.Outputs = task.Outputs
+	}
+	if task.Timeout != nil {
+		template.Timeout = task.Timeout
+	}
+	if task.RetryStrategy != nil {
+		template.RetryStrategy = task.RetryStrategy
+	}
+	if len(task.Steps) != 0 {
+		template.Steps = task.Steps
+	}
+	if len(task.Volumes) != 0 {
+		template.Volumes = task.Volumes
+	}
+	if task.Workflow != nil {
+		template.Workflow = task.Workflow
+	}
+	if task.Approval != nil {
+		template.Approval = task.Approval
+	}
+	if task.StageFailurePolicy != "" {
+		template.StageFailurePolicy = task.StageFailurePolicy
+	}
+	if len(task.Sidecars) != 0 {
+		template.Sidecars = task.Sidecars
+	}
+	if len(task.Workspaces) != 0 {
+		template.Workspaces = task.Workspaces
+	}
+	if task.Artifacts != nil {
+		template.Artifacts = task.Artifacts
+	}
+	template.PodTemplate = MergePodTemplates(template.PodTemplate, task.PodTemplate)
+	return template
+}
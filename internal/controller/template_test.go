@@ -0,0 +1,128 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Templates", func() {
+	templates := map[skyv1alpha1.TemplateRef]*skyv1alpha1.WorkflowTemplateSpec{
+		{Kind: skyv1alpha1.TemplateKindWorkflowTemplate, Name: "ci"}: {
+			Inputs:      []skyv1alpha1.Input{{Name: "branch", Value: "main"}, {Name: "target", Value: "all"}},
+			PodTemplate: &skyv1alpha1.PodTemplate{ServiceAccountName: "ci", NodeSelector: map[string]string{"pool": "ci"}},
+			Tasks: []skyv1alpha1.Task{
+				{Name: "checkout", Steps: []skyv1alpha1.Step{{Name: "clone", Image: "git"}}},
+				{
+					Name:         "build",
+					Dependencies: []skyv1alpha1.Dependency{{Name: "checkout"}},
+					TemplateRef: &skyv1alpha1.TaskTemplateRef{
+						TemplateRef: skyv1alpha1.TemplateRef{Kind: skyv1alpha1.TemplateKindClusterWorkflowTemplate, Name: "tasks"},
+						Task:        "go-build",
+					},
+				},
+			},
+		},
+		{Kind: skyv1alpha1.TemplateKindClusterWorkflowTemplate, Name: "tasks"}: {
+			Tasks: []skyv1alpha1.Task{
+				{Name: "go-build", Description: "go build", When: "true", Steps: []skyv1alpha1.Step{{Name: "build", Image: "golang"}}},
+			},
+		},
+	}
+	getTemplate := func(ref skyv1alpha1.TemplateRef) (*skyv1alpha1.WorkflowTemplateSpec, error) {
+		ref.Kind = ref.GetKind()
+		if spec, ok := templates[ref]; ok {
+			return spec, nil
+		}
+		return nil, fmt.Errorf("failed to get %s %s", ref.Kind, ref.Name)
+	}
+
+	It("should inline the workflow template and task templates", func() {
+		spec, err := resolveWorkflowSpec(skyv1alpha1.WorkflowSpec{
+			WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "ci"},
+			Inputs:              []skyv1alpha1.Input{{Name: "branch", Value: "dev"}, {Name: "extra", Value: "1"}},
+			Tasks:               []skyv1alpha1.Task{{Name: "notify", Dependencies: []skyv1alpha1.Dependency{{Name: "build"}}}},
+			PodTemplate:         &skyv1alpha1.PodTemplate{NodeSelector: map[string]string{"pool": "large"}},
+		}, getTemplate)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(spec.PodTemplate).To(Equal(&skyv1alpha1.PodTemplate{ServiceAccountName: "ci", NodeSelector: map[string]string{"pool": "large"}}))
+
+		Expect(spec.Inputs).To(Equal([]skyv1alpha1.Input{{Name: "branch", Value: "dev"}, {Name: "target", Value: "all"}, {Name: "extra", Value: "1"}}))
+		Expect(spec.Tasks).To(HaveLen(3))
+		build := spec.Tasks[1]
+		Expect(build.Name).To(Equal("build"))
+		Expect(build.TemplateRef).To(BeNil())
+		Expect(build.Description).To(Equal("go build"))
+		Expect(build.When).To(BeEmpty())
+		Expect(build.Dependencies).To(Equal([]skyv1alpha1.Dependency{{Name: "checkout"}}))
+		Expect(build.Steps).To(Equal([]skyv1alpha1.Step{{Name: "build", Image: "golang"}}))
+
+		spec.Tasks[1].Steps[0].Image = "changed"
+		Expect(templates[skyv1alpha1.TemplateRef{Kind: skyv1alpha1.TemplateKindClusterWorkflowTemplate, Name: "tasks"}].Tasks[0].Steps[0].Image).To(Equal("golang"))
+	})
+
+	It("should fail on missing templates and tasks", func() {
+		_, err := resolveWorkflowSpec(skyv1alpha1.WorkflowSpec{WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "missing"}}, getTemplate)
+		Expect(err).To(HaveOccurred())
+
+		_, err = resolveWorkflowSpec(skyv1alpha1.WorkflowSpec{Tasks: []skyv1alpha1.Task{{
+			Name: "build",
+			TemplateRef: &skyv1alpha1.TaskTemplateRef{
+				TemplateRef: skyv1alpha1.TemplateRef{Kind: skyv1alpha1.TemplateKindClusterWorkflowTemplate, Name: "tasks"},
+				Task:        "missing",
+			},
+		}}}, getTemplate)
+		Expect(err).To(MatchError("task build: ClusterWorkflowTemplate tasks has no task missing"))
+	})
+
+	Context("with a template task using workspaces and artifacts", func() {
+		template := skyv1alpha1.Task{
+			Name:       "go-build",
+			Steps:      []skyv1alpha1.Step{{Name: "build", Image: "golang"}},
+			Workspaces: []skyv1alpha1.WorkspaceBinding{{Name: "cache", MountPath: "/root/.cache"}},
+			Artifacts:  &skyv1alpha1.TaskArtifacts{Outputs: []skyv1alpha1.Artifact{{Name: "binary", Path: "/workspace/bin"}}},
+		}
+
+		It("should keep the workspaces and artifacts of the template task", func() {
+			task := mergeTaskTemplate(*template.DeepCopy(), skyv1alpha1.Task{Name: "build"})
+			Expect(task.Workspaces).To(Equal(template.Workspaces))
+			Expect(task.Artifacts).To(Equal(template.Artifacts))
+		})
+
+		It("should bind the workspaces of the referencing task", func() {
+			workspaces := []skyv1alpha1.WorkspaceBinding{{Name: "source", SubPath: "app"}, {Name: "cache", ReadOnly: true}}
+			task := mergeTaskTemplate(*template.DeepCopy(), skyv1alpha1.Task{Name: "build", Workspaces: workspaces})
+			Expect(task.Workspaces).To(Equal(workspaces))
+			Expect(task.Artifacts).To(Equal(template.Artifacts))
+		})
+
+		It("should pass the artifacts of the referencing task", func() {
+			artifacts := &skyv1alpha1.TaskArtifacts{
+				Inputs:  []skyv1alpha1.Artifact{{Name: "source", Path: "/workspace/src", From: &skyv1alpha1.ArtifactSource{Task: "checkout", Artifact: "source"}}},
+				Outputs: []skyv1alpha1.Artifact{{Name: "binary", Path: "/workspace/out"}},
+			}
+			task := mergeTaskTemplate(*template.DeepCopy(), skyv1alpha1.Task{Name: "build", Artifacts: artifacts})
+			Expect(task.Artifacts).To(Equal(artifacts))
+			Expect(task.Workspaces).To(Equal(template.Workspaces))
+		})
+	})
+})
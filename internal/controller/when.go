@@ -0,0 +1,154 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/checker"
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+const (
+	// whenCostLimit caps the cost of a when expression, like the limit of the validation rules of
+	// the API server.
+	whenCostLimit = 1000000
+	// whenValueSize is the length assumed for inputs and outputs when estimating the cost.
+	whenValueSize = 4096
+)
+
+// whenEnv declares the variables available to task when expressions.
+var whenEnv = sync.OnceValues(func() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("inputs", cel.MapType(cel.StringType, cel.StringType)),
+		cel.Variable("tasks", cel.MapType(cel.StringType, cel.DynType)),
+	)
+})
+
+// checkWhen parses and type checks a when expression.
+func checkWhen(expression string) (*cel.Env, *cel.Ast, error) {
+	env, err := whenEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ast, issues := env.Compile(expression)
+	if issues != nil && issues.Err() != nil {
+		return nil, nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, nil, fmt.Errorf("expression must evaluate to a bool, got %s", ast.OutputType())
+	}
+	return env, ast, nil
+}
+
+// compileWhen checks a when expression and returns its program, which stops once it exceeds the
+// cost limit.
+func compileWhen(expression string) (cel.Program, error) {
+	env, ast, err := checkWhen(expression)
+	if err != nil {
+		return nil, err
+	}
+	return env.Program(ast, cel.CostLimit(whenCostLimit), cel.InterruptCheckFrequency(100))
+}
+
+// ValidateWhen checks a when expression of a workflow with the given number of inputs and tasks,
+// and rejects it when its estimated cost exceeds the limit.
+func ValidateWhen(expression string, inputs, tasks int) error {
+	env, ast, err := checkWhen(expression)
+	if err != nil {
+		return err
+	}
+	cost, err := env.EstimateCost(ast, whenEstimator{inputs: inputs, tasks: tasks})
+	if err != nil {
+		return err
+	}
+	if cost.Max > whenCostLimit {
+		return fmt.Errorf("the estimated cost %d of the expression exceeds the limit of %d", cost.Max, whenCostLimit)
+	}
+	return nil
+}
+
+// whenEstimator sizes the variables of when expressions for the cost estimation.
+type whenEstimator struct {
+	inputs, tasks int
+}
+
+func (e whenEstimator) EstimateSize(element checker.AstNode) *checker.SizeEstimate {
+	path := element.Path()
+	if len(path) == 0 {
+		return nil
+	}
+	size := uint64(whenValueSize)
+	if len(path) == 1 {
+		switch path[0] {
+		case "inputs":
+			size = uint64(e.inputs)
+		case "tasks":
+			size = uint64(e.tasks)
+		}
+	}
+	return &checker.SizeEstimate{Min: 0, Max: size}
+}
+
+func (e whenEstimator) EstimateCallCost(function, overloadID string, target *checker.AstNode, args []checker.AstNode) *checker.CallEstimate {
+	return nil
+}
+
+// evaluateWhen reports whether a task runs. Tasks without when expression always run.
+func evaluateWhen(ctx context.Context, expression string, inputs []skyv1alpha1.Input, taskStatus map[string]skyv1alpha1.TaskStatus) (bool, error) {
+	if expression == "" {
+		return true, nil
+	}
+
+	program, err := compileWhen(expression)
+	if err != nil {
+		return false, err
+	}
+
+	inputValues := make(map[string]string, len(inputs))
+	for _, input := range inputs {
+		inputValues[input.Name] = input.Value
+	}
+	tasks := make(map[string]any, len(taskStatus))
+	for name, status := range taskStatus {
+		outputs := make(map[string]string, len(status.Outputs))
+		for _, output := range status.Outputs {
+			outputs[output.Name] = output.Value
+		}
+		tasks[name] = map[string]any{
+			"status":  string(status.Status),
+			"outputs": outputs,
+		}
+	}
+
+	value, _, err := program.ContextEval(ctx, map[string]any{
+		"inputs": inputValues,
+		"tasks":  tasks,
+	})
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.Value().(bool)
+	if !ok {
+		return false, fmt.Errorf("expression must evaluate to a bool, got %v", value.Type())
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("When expressions", func() {
+	inputs := []skyv1alpha1.Input{{Name: "branch", Value: "main"}}
+	taskStatus := map[string]skyv1alpha1.TaskStatus{
+		"test": {
+			Name:    "test",
+			Status:  skyv1alpha1.TaskStatusSucceeded,
+			Outputs: []*skyv1alpha1.Output{{Name: "coverage", Value: "83.5"}},
+		},
+	}
+
+	It("should evaluate inputs and upstream outputs", func() {
+		Expect(evaluateWhen(context.Background(), `inputs.branch == "main"`, inputs, taskStatus)).To(BeTrue())
+		Expect(evaluateWhen(context.Background(), `inputs.branch == "dev"`, inputs, taskStatus)).To(BeFalse())
+		Expect(evaluateWhen(context.Background(), `double(tasks.test.outputs.coverage) > 80.0`, inputs, taskStatus)).To(BeTrue())
+		Expect(evaluateWhen(context.Background(), `tasks.test.status == "Succeeded"`, inputs, taskStatus)).To(BeTrue())
+		Expect(evaluateWhen(context.Background(), "", inputs, taskStatus)).To(BeTrue())
+	})
+
+	It("should reject invalid expressions", func() {
+		_, err := evaluateWhen(context.Background(), `inputs.branch`, inputs, taskStatus)
+		Expect(err).To(HaveOccurred())
+
+		_, err = evaluateWhen(context.Background(), `inputs.branch ==`, inputs, taskStatus)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should stop expressions exceeding the cost limit", func() {
+		expression := `[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(a, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(b,
+			[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(c, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(d,
+			[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(e, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(f, a + b + c + d + e + f >= 0))))))`
+		Expect(ValidateWhen(expression, 1, 1)).To(MatchError(ContainSubstring("exceeds the limit of 1000000")))
+		_, err := evaluateWhen(context.Background(), expression, inputs, taskStatus)
+		Expect(err).To(MatchError(ContainSubstring("cost limit exceeded")))
+
+		Expect(ValidateWhen(`tasks.exists(name, tasks[name].status == "Failed") || inputs.branch.startsWith("release-")`, 1, 20)).To(Succeed())
+		Expect(ValidateWhen(`inputs.branch ==`, 1, 1)).To(HaveOccurred())
+	})
+
+	It("should let tasks skipped by their condition satisfy dependents", func() {
+		d, err := BuildDAG([]skyv1alpha1.Task{
+			{Name: "deploy", When: `inputs.branch == "main"`},
+			{Name: "smoke", Dependencies: []skyv1alpha1.Dependency{{Name: "deploy"}}},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		next, skipped := FindSchedulableNodes(d, map[string]skyv1alpha1.TaskStatus{
+			"deploy": {Name: "deploy", Status: skyv1alpha1.TaskStatusSkipped, Reason: skyv1alpha1.TaskReasonWhenFalse},
+		})
+		Expect(next).To(HaveLen(1))
+		Expect(skipped).To(BeEmpty())
+	})
+})
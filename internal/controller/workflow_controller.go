@@ -18,40 +18,50 @@ package controller
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apiserver/pkg/storage/names"
+	"slices"
+	"strconv"
 	"time"
 
 	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
 // WorkflowReconciler reconciles a Workflow object
 type WorkflowReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// ArtifactRepository stores the artifacts of the tasks.
+	ArtifactRepository ArtifactRepository
+	// KubeClient reads the logs of the steps whose outputs do not fit into their termination message.
+	KubeClient kubernetes.Interface
+	// APIReader reads the workspace claims, which are not cached, and the child workflows the cache
+	// may not have seen yet.
+	APIReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=sky.my.domain,resources=workflows,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=sky.my.domain,resources=workflows/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=sky.my.domain,resources=workflows/finalizers,verbs=update
-
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the Workflow object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
-//
-// For more details, check Reconcile and its Result here:
-// - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.18.4/pkg/reconcile
+// +kubebuilder:rbac:groups=sky.my.domain,resources=workflowtemplates;clusterworkflowtemplates,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=persistentvolumeclaims,verbs=get;create;delete
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update
+
+// Reconcile resolves the templates of the workflow, records the status of its tasks and starts
+// the tasks whose dependencies are satisfied, as Pods, child workflows or approvals. The workflow
+// completes once its tasks and finally tasks completed.
 func (r *WorkflowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	logger := log.FromContext(ctx)
 
@@ -74,188 +84,567 @@ func (r *WorkflowReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		return ctrl.Result{}, r.clearFinalizers(ctx, workflow)
 	}
 
+	switch workflow.Status.Status {
+	case skyv1alpha1.WorkFlowStatusSuccess, skyv1alpha1.WorkFlowStatusFailed, skyv1alpha1.WorkFlowStatusCancel:
+		return ctrl.Result{}, nil
+	}
+
+	if _err := r.resolveTemplates(ctx, workflow); _err != nil {
+		logger.Info("WorkFlow templates could not be resolved", "reason", _err.Error())
+		workflow.Status.Message = fmt.Sprintf("WorkFlow templates could not be resolved: %v", _err)
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+		if _err = r.updateStatus(ctx, workflow, skyv1alpha1.WorkflowReasonInvalidSpec); _err != nil {
+			logger.Error(_err, "Failed to update WorkFlow status")
+			return ctrl.Result{}, _err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	if workflow.ValidateUniqueTaskNames() {
 		logger.Info("WorkFlow has duplicate task names")
 		workflow.Status.Message = "WorkFlow has duplicate task names"
 		workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
-		if _err := r.Client.Update(ctx, workflow); _err != nil {
+		if _err := r.updateStatus(ctx, workflow, skyv1alpha1.WorkflowReasonInvalidSpec); _err != nil {
 			logger.Error(_err, "Failed to update WorkFlow status")
 			return ctrl.Result{}, _err
 		}
 		return ctrl.Result{}, nil
 	}
 
-	taskStatus := make(map[string]skyv1alpha1.TaskStatus)
+	allTasks := slices.Concat(workflow.GetTasks(), workflow.GetFinally())
+	d, err := BuildDAG(allTasks)
+	if err == nil {
+		err = d.Validate()
+	}
+	if err == nil {
+		err = validateFinally(workflow.GetTasks(), workflow.GetFinally())
+	}
+	if err != nil {
+		logger.Info("WorkFlow has invalid dependencies", "reason", err.Error())
+		workflow.Status.Message = fmt.Sprintf("WorkFlow has invalid dependencies: %v", err)
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+		if _err := r.updateStatus(ctx, workflow, skyv1alpha1.WorkflowReasonInvalidSpec); _err != nil {
+			logger.Error(_err, "Failed to update WorkFlow status")
+			return ctrl.Result{}, _err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	pods, err := r.listTaskPods(ctx, workflow)
+	if err != nil {
+		logger.Error(err, "Failed to list task Pods")
+		return ctrl.Result{}, err
+	}
+	children, err := r.listChildWorkflows(ctx, workflow)
+	if err != nil {
+		logger.Error(err, "Failed to list child WorkFlows")
+		return ctrl.Result{}, err
+	}
 
-	var compeletedCount int
-	var isFailed bool
+	tasks := make(map[string]skyv1alpha1.Task, len(allTasks))
+	for _, task := range allTasks {
+		tasks[task.Name] = task
+	}
+	finally := make(map[string]bool, len(workflow.GetFinally()))
+	for _, task := range workflow.GetFinally() {
+		finally[task.Name] = true
+	}
+
+	taskStatus := make(map[string]skyv1alpha1.TaskStatus)
 	for _, task := range workflow.Status.TaskStatus {
-		switch task.Status {
-		case corev1.PodFailed:
-			compeletedCount += 1
+		if isTaskCompleted(task.Status) {
 			taskStatus[task.Name] = task
-			isFailed = true
 			continue
-		case corev1.PodSucceeded:
-			compeletedCount += 1
-			taskStatus[task.Name] = task
+		}
+		if approvalTask := statusTask(tasks, task); approvalTask.Approval != nil {
+			taskStatus[task.Name] = approvalTaskStatus(approvalTask, task, workflow.Annotations, time.Now())
 			continue
 		}
-		_pod, _err := r.getPod(ctx, task.PodName, workflow.GetNamespace())
-		if _err != nil {
-			logger.Error(_err, "Failed to get Pod")
-			return ctrl.Result{}, _err
+		if statusTask(tasks, task).Workflow != nil {
+			if child, ok := children[task.Name]; ok {
+				status := childTaskStatus(task.Name, child)
+				status.Parent = task.Parent
+				status.Parameters = task.Parameters
+				if task.StartTime != nil {
+					status.StartTime = task.StartTime
+				}
+				task = status
+			}
+			taskStatus[task.Name] = task
+			continue
 		}
-
-		status := skyv1alpha1.TaskStatus{
-			Name:    task.Name,
-			PodName: _pod.Name,
-			Status:  _pod.Status.Phase,
+		_pod, ok := pods[task.Name]
+		if !ok || podAttempt(_pod) != len(task.Attempts) {
+			// The Pod of the current attempt has not reached the cache yet, its events will trigger
+			// another reconcile. Retrying tasks get their Pod once the backoff expired.
+			taskStatus[task.Name] = task
+			continue
 		}
-		if _pod.Status.Phase == corev1.PodFailed || _pod.Status.Phase == corev1.PodSucceeded {
-			status.Message = _pod.Status.Message
-			state := _pod.Status.ContainerStatuses[len(_pod.Spec.Containers)-1].State
-			if state.Terminated != nil {
-				status.CompletionTime = &state.Terminated.FinishedAt
-			}
+		status := podTaskStatus(ctx, task.Name, _pod)
+		status.Attempts = task.Attempts
+		status.Parent = task.Parent
+		status.Parameters = task.Parameters
+		// Retried tasks started with their first attempt.
+		if task.StartTime != nil {
+			status.StartTime = task.StartTime
 		}
-		for _, containerStatus := range _pod.Status.ContainerStatuses {
-			if containerStatus.State.Terminated != nil {
-				if containerStatus.State.Terminated.Message != "" {
-					outputs := []*skyv1alpha1.Output{}
-					if _err = json.Unmarshal([]byte(containerStatus.State.Terminated.Message), &outputs); _err == nil {
-						status.Outputs = outputs
-					} else {
-						logger.Error(_err, "Failed to unmarshal results")
-					}
+		if status.Status == skyv1alpha1.TaskStatusFailed {
+			reason, exitCode, message := podFailure(_pod)
+			if shouldRetry(statusTask(tasks, task).RetryStrategy, len(status.Attempts), reason, exitCode) {
+				logger.Info("Retrying failed task", "task", task.Name, "reason", reason, "attempt", len(status.Attempts)+1)
+				status.Attempts = append(status.Attempts, skyv1alpha1.TaskAttempt{
+					PodName:        _pod.Name,
+					Reason:         reason,
+					ExitCode:       exitCode,
+					Message:        message,
+					StartTime:      _pod.Status.StartTime,
+					CompletionTime: status.CompletionTime,
+				})
+				if status.CompletionTime == nil {
+					now := metav1.Now()
+					status.Attempts[len(status.Attempts)-1].CompletionTime = &now
 				}
+				status.Status = skyv1alpha1.TaskStatusRetrying
+				status.Message = message
+				status.CompletionTime = nil
+				status.Outputs = nil
 			}
 		}
+		if _err := r.storeOffloadedOutputs(ctx, workflow, &status, _pod); _err != nil {
+			logger.Error(_err, "Failed to store task outputs")
+			return ctrl.Result{}, _err
+		}
 		taskStatus[task.Name] = status
 	}
 
-	workflow.Status.TaskStatus = taskStatus
-
-	if compeletedCount == len(workflow.Status.TaskStatus) && len(workflow.Status.TaskStatus) != 0 {
-		workflow.Status.Status = skyv1alpha1.WorkFlowStatusSuccess
-		if isFailed {
-			workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+	// Pods created by a previous reconcile whose status update was not observed yet are adopted
+	// instead of being created a second time.
+	for taskName, _pod := range pods {
+		if _, ok := taskStatus[taskName]; !ok {
+			status := podTaskStatus(ctx, taskName, _pod)
+			if _err := r.storeOffloadedOutputs(ctx, workflow, &status, _pod); _err != nil {
+				logger.Error(_err, "Failed to store task outputs")
+				return ctrl.Result{}, _err
+			}
+			taskStatus[taskName] = status
+		}
+	}
+	for taskName, child := range children {
+		if _, ok := taskStatus[taskName]; !ok {
+			taskStatus[taskName] = childTaskStatus(taskName, child)
 		}
 	}
 
-	if _err := r.Status().Update(ctx, workflow); _err != nil {
-		logger.Error(_err, "Failed to update WorkFlow")
+	// Outputs stored in the ConfigMap of the workflow are substituted like the others.
+	if _err := r.loadOffloadedOutputs(ctx, workflow, taskStatus); _err != nil {
+		logger.Error(_err, "Failed to load task outputs")
 		return ctrl.Result{}, _err
 	}
 
-	d, err := BuildDAG(workflow.Spec.Tasks)
-	if err != nil {
-		logger.Error(err, "Failed to build DAG")
-		return ctrl.Result{}, err
+	// Fanned-out tasks complete with their last instance.
+	for name, status := range taskStatus {
+		if task, ok := tasks[name]; ok && task.IsFanOut() && !isTaskCompleted(status.Status) {
+			taskStatus[name] = aggregateInstances(task, status, taskStatus)
+		}
 	}
 
-	if !d.Validate() {
-		logger.Info("WorkFlow has invalid dependencies")
-		workflow.Status.Message = "WorkFlow has invalid dependencies"
-		workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
-		if _err := r.Client.Update(ctx, workflow); _err != nil {
-			logger.Error(_err, "Failed to update WorkFlow status")
+	workflow.Status.TaskStatus = taskStatus
+
+	// Cancelled workflows still run their finally tasks.
+	if workflow.Spec.Cancel {
+		if _err := r.cancelTasks(ctx, workflow, finally); _err != nil {
+			logger.Error(_err, "Failed to cancel WorkFlow")
 			return ctrl.Result{}, _err
 		}
-		return ctrl.Result{}, nil
 	}
 
-	nextNodes := FindSchedulableNodes(d, FindCompletedTasks(workflow), taskStatus)
-	if len(nextNodes) == 0 {
-		if workflow.Status.Status == skyv1alpha1.WorkFlowStatusSuccess || workflow.Status.Status == skyv1alpha1.WorkFlowStatusFailed {
-			return ctrl.Result{}, nil
+	// Skipping a task can leave its dependents unsatisfiable or unblock them, so resolve until
+	// nothing changes.
+	var nextNodes []*Node
+	for {
+		var skippedNodes []*Node
+		nextNodes, skippedNodes = FindSchedulableNodes(d, taskStatus)
+		// Finally tasks wait for every task to complete.
+		tasksCompleted, _ := tasksOutcome(workflow.GetTasks(), taskStatus)
+		nextNodes = holdFinally(nextNodes, finally, tasksCompleted)
+		skippedNodes = holdFinally(skippedNodes, finally, tasksCompleted)
+		changed := len(skippedNodes) != 0
+		now := metav1.Now()
+		for _, node := range skippedNodes {
+			taskStatus[node.Name] = skyv1alpha1.TaskStatus{
+				Name:           node.Name,
+				Message:        "dependencies not satisfied",
+				Status:         skyv1alpha1.TaskStatusSkipped,
+				Reason:         skyv1alpha1.TaskReasonDependenciesNotSatisfied,
+				CompletionTime: &now,
+			}
+		}
+
+		var runnableNodes []*Node
+		for _, node := range nextNodes {
+			run, _err := evaluateWhen(ctx, tasks[node.Name].When, workflow.GetInputs(), taskStatus)
+			switch {
+			case _err != nil:
+				logger.Info("Failed to evaluate when expression", "task", node.Name, "reason", _err.Error())
+				taskStatus[node.Name] = skyv1alpha1.TaskStatus{
+					Name:           node.Name,
+					Message:        fmt.Sprintf("invalid when expression: %v", _err),
+					Status:         skyv1alpha1.TaskStatusFailed,
+					CompletionTime: &now,
+				}
+				changed = true
+			case !run:
+				taskStatus[node.Name] = skyv1alpha1.TaskStatus{
+					Name:           node.Name,
+					Message:        "when expression is false",
+					Status:         skyv1alpha1.TaskStatusSkipped,
+					Reason:         skyv1alpha1.TaskReasonWhenFalse,
+					CompletionTime: &now,
+				}
+				changed = true
+			case tasks[node.Name].IsFanOut():
+				// Fanned-out tasks start through their instances.
+				parameters, _err := expandTask(tasks[node.Name], workflow)
+				if _err != nil {
+					taskStatus[node.Name] = skyv1alpha1.TaskStatus{
+						Name:           node.Name,
+						Message:        _err.Error(),
+						Status:         skyv1alpha1.TaskStatusFailed,
+						CompletionTime: &now,
+					}
+					changed = true
+					continue
+				}
+				parent := newInstanceStatuses(tasks[node.Name], parameters, taskStatus)
+				if len(parameters) == 0 {
+					parent = aggregateInstances(tasks[node.Name], parent, taskStatus)
+					changed = true
+				}
+				taskStatus[node.Name] = parent
+			default:
+				runnableNodes = append(runnableNodes, node)
+			}
+		}
+		nextNodes = runnableNodes
+
+		if !changed {
+			break
 		}
-		return ctrl.Result{
-			RequeueAfter: 5 * time.Second,
-		}, nil
 	}
 
-	nextTasks := FindSchedulableTasks(nextNodes, workflow.Spec.Tasks)
-	if len(nextTasks) == 0 {
-		if workflow.Status.Status == skyv1alpha1.WorkFlowStatusSuccess || workflow.Status.Status == skyv1alpha1.WorkFlowStatusFailed {
-			return ctrl.Result{}, nil
+	var nextTasks []skyv1alpha1.Task
+	var requeueAfter time.Duration
+	// Nothing new starts while suspended, resuming bumps the generation and triggers a reconcile.
+	if !workflow.Spec.Suspend || workflow.Spec.Cancel {
+		nextTasks = FindSchedulableTasks(nextNodes, allTasks)
+
+		running := runningInstances(taskStatus)
+		for _, name := range sortedTaskNames(taskStatus) {
+			status := taskStatus[name]
+			// Instances of a task limited by maxParallel wait for a running instance to complete.
+			if status.Parent != "" {
+				if limit := maxParallel(tasks[status.Parent]); limit != 0 && running[status.Parent] >= limit {
+					continue
+				}
+			}
+			// Approval timeouts expire without any event either.
+			if task := statusTask(tasks, status); task.Approval != nil && !isTaskCompleted(status.Status) {
+				if wait := approvalDelay(task, status, time.Now()); wait > 0 && (requeueAfter == 0 || wait < requeueAfter) {
+					requeueAfter = wait
+				}
+			}
+			switch {
+			case status.Parent != "" && status.PodName == "" && status.WorkflowName == "" && status.Status == skyv1alpha1.TaskStatusPending:
+				nextTasks = append(nextTasks, statusTask(tasks, status))
+				running[status.Parent]++
+			case status.Status == skyv1alpha1.TaskStatusRetrying:
+				// Retries are the only thing the controller waits for without a Pod event, they are requeued.
+				task := statusTask(tasks, status)
+				if wait := retryDelay(task.RetryStrategy, status, time.Now()); wait > 0 {
+					if requeueAfter == 0 || wait < requeueAfter {
+						requeueAfter = wait
+					}
+					continue
+				}
+				nextTasks = append(nextTasks, task)
+				if status.Parent != "" {
+					running[status.Parent]++
+				}
+			}
 		}
-		return ctrl.Result{
-			RequeueAfter: 5 * time.Second,
-		}, nil
 	}
 
+	var workspaceConflicts map[string]string
+	if len(nextTasks) != 0 {
+		if workspaceConflicts, err = r.createWorkspaces(ctx, workflow); err != nil {
+			logger.Error(err, "Failed to create workspaces")
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Running tasks are not polled, the Pod watch triggers the next reconcile on phase changes.
 	for _, task := range nextTasks {
-		pod, _err := r.createPod(ctx, task, workflow)
+		previous := taskStatus[task.Name]
+		if task.Approval != nil {
+			logger.Info("Waiting for approval", "task", task.Name)
+			taskStatus[task.Name] = newApprovalStatus(task, previous, time.Now())
+			if wait := approvalDelay(task, taskStatus[task.Name], time.Now()); wait > 0 && (requeueAfter == 0 || wait < requeueAfter) {
+				requeueAfter = wait
+			}
+			continue
+		}
+		if task.Workflow != nil {
+			child, conflict, _err := r.createChildWorkflow(ctx, task, workflow)
+			if _err != nil {
+				logger.Error(_err, "Failed to create child WorkFlow")
+				workflow.Status.Message = _err.Error()
+				workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
+				if _err = r.updateStatus(ctx, workflow, skyv1alpha1.WorkflowReasonCreateFailed); _err != nil {
+					logger.Error(_err, "Failed to update WorkFlow")
+				}
+				return ctrl.Result{}, _err
+			}
+			if conflict != "" {
+				logger.Info("Task cannot create its child WorkFlow", "task", task.Name, "reason", conflict)
+				now := metav1.Now()
+				taskStatus[task.Name] = skyv1alpha1.TaskStatus{
+					Name:           task.Name,
+					Status:         skyv1alpha1.TaskStatusFailed,
+					Reason:         skyv1alpha1.TaskReasonWorkflowConflict,
+					Message:        conflict,
+					StartTime:      &now,
+					CompletionTime: &now,
+					Parent:         previous.Parent,
+					Parameters:     previous.Parameters,
+				}
+				continue
+			}
+			taskStatus[task.Name] = skyv1alpha1.TaskStatus{
+				Name:         task.Name,
+				WorkflowName: child.Name,
+				Status:       skyv1alpha1.TaskStatusPending,
+				StartTime:    child.CreationTimestamp.DeepCopy(),
+				Parent:       previous.Parent,
+				Parameters:   previous.Parameters,
+			}
+			continue
+		}
+		if conflict := workspaceConflict(task, workspaceConflicts); conflict != "" {
+			logger.Info("Task cannot mount its workspaces", "task", task.Name, "reason", conflict)
+			now := metav1.Now()
+			taskStatus[task.Name] = skyv1alpha1.TaskStatus{
+				Name:           task.Name,
+				Status:         skyv1alpha1.TaskStatusFailed,
+				Reason:         skyv1alpha1.TaskReasonWorkspaceConflict,
+				Message:        conflict,
+				StartTime:      &now,
+				CompletionTime: &now,
+				Attempts:       previous.Attempts,
+				Parent:         previous.Parent,
+				Parameters:     previous.Parameters,
+			}
+			continue
+		}
+		pod, _err := r.createPod(ctx, task, len(previous.Attempts), workflow)
 		if _err != nil {
 			logger.Error(_err, "Failed to create Task")
 			workflow.Status.Message = _err.Error()
 			workflow.Status.Status = skyv1alpha1.WorkFlowStatusFailed
-			if _err = r.Status().Update(ctx, workflow); _err != nil {
+			if _err = r.updateStatus(ctx, workflow, skyv1alpha1.WorkflowReasonCreateFailed); _err != nil {
 				logger.Error(_err, "Failed to update WorkFlow")
 			}
 			return ctrl.Result{}, _err
 		}
+		startTime := previous.StartTime
+		if startTime == nil {
+			startTime = pod.CreationTimestamp.DeepCopy()
+		}
 		taskStatus[task.Name] = skyv1alpha1.TaskStatus{
-			Name:    task.Name,
-			PodName: pod.Name,
-			Status:  pod.Status.Phase,
+			Name:       task.Name,
+			PodName:    pod.Name,
+			Status:     taskPhase(pod.Status.Phase),
+			StartTime:  startTime,
+			Attempts:   previous.Attempts,
+			Parent:     previous.Parent,
+			Parameters: previous.Parameters,
 		}
-		workflow.Finalizers = append(workflow.Finalizers, pod.Name)
 	}
 
-	if workflow.Status.Status == "" {
-		workflow.Status.Status = skyv1alpha1.WorkFlowStatusRunning
+	r.setWorkflowStatus(workflow)
+	switch workflow.Status.Status {
+	case skyv1alpha1.WorkFlowStatusSuccess, skyv1alpha1.WorkFlowStatusFailed, skyv1alpha1.WorkFlowStatusCancel:
+		if _err := r.deleteWorkspaces(ctx, workflow); _err != nil {
+			logger.Error(_err, "Failed to delete workspaces")
+			return ctrl.Result{}, _err
+		}
 	}
-	workflow.Status.TaskStatus = taskStatus
-	if _err := r.Status().Update(ctx, workflow); _err != nil {
+	if _err := r.updateStatus(ctx, workflow, ""); _err != nil {
 		logger.Error(_err, "Failed to update WorkFlow", "workflow", workflow.Name)
 		return ctrl.Result{}, _err
 	}
 
-	return ctrl.Result{
-		RequeueAfter: 5,
-	}, nil
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
 }
 
-func (r *WorkflowReconciler) getPod(ctx context.Context, podName, namespace string) (*corev1.Pod, error) {
-	pod := &corev1.Pod{}
-	if _err := r.Client.Get(ctx, client.ObjectKey{Name: podName, Namespace: namespace}, pod); _err != nil {
-		return nil, _err
+// runningInstances counts the instances of every fanned-out task that have a Pod in progress.
+func runningInstances(taskStatus map[string]skyv1alpha1.TaskStatus) map[string]int {
+	running := map[string]int{}
+	for _, status := range taskStatus {
+		if status.Parent != "" && (status.PodName != "" || status.WorkflowName != "") && !isTaskCompleted(status.Status) && status.Status != skyv1alpha1.TaskStatusRetrying {
+			running[status.Parent]++
+		}
+	}
+	return running
+}
+
+// setWorkflowStatus finishes the workflow once every task and finally task completed. The workflow
+// fails if any of them failed, even when a dependent task handled the failure.
+func (r *WorkflowReconciler) setWorkflowStatus(workflow *skyv1alpha1.Workflow) {
+	status, _ := exitStatus(workflow)
+	finallyCompleted, finallyFailed := tasksOutcome(workflow.GetFinally(), workflow.Status.TaskStatus)
+
+	if status == skyv1alpha1.WorkFlowStatusRunning || !finallyCompleted {
+		workflow.Status.Status = skyv1alpha1.WorkFlowStatusRunning
+		if workflow.Spec.Suspend && !workflow.Spec.Cancel {
+			workflow.Status.Status = skyv1alpha1.WorkFlowStatusPause
+		}
+		return
+	}
+
+	if status == skyv1alpha1.WorkFlowStatusSuccess && len(finallyFailed) != 0 {
+		status = skyv1alpha1.WorkFlowStatusFailed
+	}
+	workflow.Status.Status = status
+	if status == skyv1alpha1.WorkFlowStatusCancel {
+		workflow.Status.Message = "WorkFlow cancelled"
 	}
-	return pod, nil
+	if workflow.Status.CompletionTime == nil {
+		now := metav1.Now()
+		workflow.Status.CompletionTime = &now
+	}
+	workflow.Status.Outputs = workflowOutputs(workflow)
 }
 
-func (r *WorkflowReconciler) findPod(ctx context.Context, workflow *skyv1alpha1.Workflow) (*corev1.Pod, error) {
+// listTaskPods returns the Pod of the latest attempt of every task controlled by the workflow, keyed
+// by task name. The lookup is served from the informer cache populated by the Pod watch.
+func (r *WorkflowReconciler) listTaskPods(ctx context.Context, workflow *skyv1alpha1.Workflow) (map[string]*corev1.Pod, error) {
 	pods := &corev1.PodList{}
 
-	if err := r.Client.List(ctx, pods, client.HasLabels{taskLabelKey}, client.InNamespace(workflow.GetNamespace())); err != nil {
+	if err := r.Client.List(ctx, pods,
+		client.InNamespace(workflow.GetNamespace()),
+		client.MatchingLabels{workflowLabelKey: workflow.GetName()},
+	); err != nil {
 		return nil, err
 	}
-	for _, _pod := range pods.Items {
-		if metav1.IsControlledBy(&_pod, workflow) {
-			return &_pod, nil
+
+	taskPods := make(map[string]*corev1.Pod, len(pods.Items))
+	for i := range pods.Items {
+		_pod := &pods.Items[i]
+		if !metav1.IsControlledBy(_pod, workflow) {
+			continue
+		}
+		taskName := _pod.Labels[taskLabelKey]
+		if latest, ok := taskPods[taskName]; ok && podAttempt(latest) > podAttempt(_pod) {
+			continue
 		}
+		taskPods[taskName] = _pod
 	}
 
-	return nil, nil
+	return taskPods, nil
 }
 
-func (r *WorkflowReconciler) createPod(ctx context.Context, task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow) (*corev1.Pod, error) {
+// podTaskStatus converts the observed state of a task Pod into a TaskStatus.
+func podTaskStatus(ctx context.Context, taskName string, _pod *corev1.Pod) skyv1alpha1.TaskStatus {
+	logger := log.FromContext(ctx)
+
+	status := skyv1alpha1.TaskStatus{
+		Name:      taskName,
+		PodName:   _pod.Name,
+		Status:    taskPhase(_pod.Status.Phase),
+		StartTime: _pod.CreationTimestamp.DeepCopy(),
+		Steps:     stepStatuses(_pod),
+	}
+	if _pod.Status.Phase == corev1.PodFailed || _pod.Status.Phase == corev1.PodSucceeded {
+		status.Message = _pod.Status.Message
+		if len(_pod.Status.ContainerStatuses) != 0 {
+			state := _pod.Status.ContainerStatuses[len(_pod.Status.ContainerStatuses)-1].State
+			if state.Terminated != nil {
+				status.CompletionTime = &state.Terminated.FinishedAt
+			}
+		}
+	}
+	for _, containerStatus := range _pod.Status.ContainerStatuses {
+		if containerStatus.State.Terminated != nil {
+			if containerStatus.State.Terminated.Message != "" {
+				outputs, _err := parseTerminationOutputs(containerStatus.State.Terminated.Message, taskName, _pod.Labels[workflowLabelKey])
+				if _err == nil {
+					status.Outputs = outputs
+				} else {
+					logger.Error(_err, "Failed to unmarshal results")
+				}
+			}
+		}
+	}
+	return status
+}
+
+func (r *WorkflowReconciler) createPod(ctx context.Context, task skyv1alpha1.Task, attempt int, workFlow *skyv1alpha1.Workflow) (*corev1.Pod, error) {
 	podName := names.SimpleNameGenerator.GenerateName(fmt.Sprintf("%s-%s-", workFlow.Name, task.Name))
 
 	coreV1Pod, err := generatePod(ctx, task, task.Steps, task.Name, podName, task.Outputs, workFlow)
 	if err != nil {
 		return coreV1Pod, err
 	}
+	coreV1Pod.Labels[taskAttemptLabelKey] = strconv.Itoa(attempt)
+	if _err := addArtifacts(coreV1Pod, task, workFlow, r.ArtifactRepository); _err != nil {
+		return coreV1Pod, _err
+	}
 
 	if _err := r.Client.Create(ctx, coreV1Pod); _err != nil {
-		return coreV1Pod, err
+		return coreV1Pod, _err
 	}
 
 	return coreV1Pod, nil
 }
 
+// cancelTasks deletes the Pods of the unfinished tasks and marks them and the tasks that never
+// started as cancelled. Finally tasks are left to run.
+func (r *WorkflowReconciler) cancelTasks(ctx context.Context, workflow *skyv1alpha1.Workflow, finally map[string]bool) error {
+	logger := log.FromContext(ctx)
+
+	now := metav1.Now()
+	for _, task := range workflow.GetTasks() {
+		if _, ok := workflow.Status.TaskStatus[task.Name]; !ok {
+			workflow.Status.TaskStatus[task.Name] = skyv1alpha1.TaskStatus{Name: task.Name}
+		}
+	}
+	for _, name := range sortedTaskNames(workflow.Status.TaskStatus) {
+		status := workflow.Status.TaskStatus[name]
+		if isTaskCompleted(status.Status) || finally[name] || finally[status.Parent] {
+			continue
+		}
+		if status.WorkflowName != "" {
+			if err := r.cancelChildWorkflow(ctx, workflow, status.WorkflowName); err != nil {
+				return err
+			}
+		}
+		if status.PodName != "" && status.Status != skyv1alpha1.TaskStatusRetrying {
+			_pod := &corev1.Pod{}
+			_pod.Name = status.PodName
+			_pod.Namespace = workflow.Namespace
+			if err := r.Client.Delete(ctx, _pod, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+			logger.Info("Deleted task Pod of cancelled WorkFlow", "task", name, "pod", status.PodName)
+		}
+		status.Status = skyv1alpha1.TaskStatusCancelled
+		status.Message = "workflow cancelled"
+		status.CompletionTime = &now
+		workflow.Status.TaskStatus[name] = status
+	}
+	return nil
+}
+
 func (r *WorkflowReconciler) clearFinalizers(ctx context.Context, workflow *skyv1alpha1.Workflow) error {
 	workflow.Finalizers = []string{}
 	return r.Client.Update(ctx, workflow)
@@ -270,6 +659,9 @@ func (r *WorkflowReconciler) setStartTime(workflow *skyv1alpha1.Workflow) {
 // SetupWithManager sets up the controller with the Manager.
 func (r *WorkflowReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&skyv1alpha1.Workflow{}).
+		// Approvals are decided through annotations, which do not change the generation.
+		For(&skyv1alpha1.Workflow{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&corev1.Pod{}).
+		Owns(&skyv1alpha1.Workflow{}).
 		Complete(r)
 }
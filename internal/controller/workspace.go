@@ -0,0 +1,162 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// workspaceClaimName names the PersistentVolumeClaim of a workspace for a run.
+func workspaceClaimName(workflowName, workspaceName string) string {
+	return fmt.Sprintf("%s-%s", workflowName, workspaceName)
+}
+
+func workspaceVolumeName(workspaceName string) string {
+	return fmt.Sprintf("workspace-%s", workspaceName)
+}
+
+// newWorkspaceClaim returns the PersistentVolumeClaim of a workspace, controlled by the workflow so
+// that retained claims are deleted with it.
+func newWorkspaceClaim(workspace skyv1alpha1.Workspace, workFlow *skyv1alpha1.Workflow) *v1.PersistentVolumeClaim {
+	return &v1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      workspaceClaimName(workFlow.Name, workspace.Name),
+			Namespace: workFlow.Namespace,
+			Labels: map[string]string{
+				workflowLabelKey: workFlow.Name,
+			},
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(workFlow, schema.GroupVersionKind{
+					Kind:    skyv1alpha1.KindName,
+					Group:   skyv1alpha1.GroupVersion.Group,
+					Version: skyv1alpha1.GroupVersion.Version,
+				}),
+			},
+		},
+		Spec: *workspace.VolumeClaimTemplate.DeepCopy(),
+	}
+}
+
+// workspaceVolumes returns the volumes of the workspaces a task uses and their mounts.
+func workspaceVolumes(task skyv1alpha1.Task, workFlow *skyv1alpha1.Workflow) ([]v1.Volume, []v1.VolumeMount, error) {
+	var volumes []v1.Volume
+	var mounts []v1.VolumeMount
+	for _, binding := range task.Workspaces {
+		declared := false
+		for _, workspace := range workFlow.GetWorkspaces() {
+			declared = declared || workspace.Name == binding.Name
+		}
+		if !declared {
+			return nil, nil, fmt.Errorf("task %s uses undeclared workspace %s", task.Name, binding.Name)
+		}
+
+		volumes = append(volumes, v1.Volume{
+			Name: workspaceVolumeName(binding.Name),
+			VolumeSource: v1.VolumeSource{
+				PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+					ClaimName: workspaceClaimName(workFlow.Name, binding.Name),
+					ReadOnly:  binding.ReadOnly,
+				},
+			},
+		})
+		mounts = append(mounts, v1.VolumeMount{
+			Name:      workspaceVolumeName(binding.Name),
+			MountPath: binding.GetMountPath(),
+			SubPath:   binding.SubPath,
+			ReadOnly:  binding.ReadOnly,
+		})
+	}
+	return volumes, mounts, nil
+}
+
+// createWorkspaces creates the claims of the workspaces that do not exist yet. Claims with the
+// same name not controlled by the workflow, such as the claim of another workflow whose name and
+// workspace join into the same name, are not used: their workspaces are returned with the reason.
+func (r *WorkflowReconciler) createWorkspaces(ctx context.Context, workflow *skyv1alpha1.Workflow) (map[string]string, error) {
+	logger := log.FromContext(ctx)
+
+	conflicts := make(map[string]string)
+	for _, workspace := range workflow.GetWorkspaces() {
+		claim := newWorkspaceClaim(workspace, workflow)
+		if err := r.Client.Create(ctx, claim); err != nil {
+			if !apierrors.IsAlreadyExists(err) {
+				return nil, err
+			}
+			existing := &v1.PersistentVolumeClaim{}
+			if err := r.APIReader.Get(ctx, client.ObjectKeyFromObject(claim), existing); err != nil {
+				return nil, err
+			}
+			if !metav1.IsControlledBy(existing, workflow) {
+				conflicts[workspace.Name] = fmt.Sprintf("claim %s of workspace %s already exists and is not controlled by the workflow", claim.Name, workspace.Name)
+			}
+			continue
+		}
+		logger.Info("Created workspace claim", "workspace", workspace.Name, "claim", claim.Name)
+	}
+	return conflicts, nil
+}
+
+// workspaceConflict returns why a task cannot mount one of its workspaces, empty when it can.
+func workspaceConflict(task skyv1alpha1.Task, conflicts map[string]string) string {
+	for _, binding := range task.Workspaces {
+		if conflict, ok := conflicts[binding.Name]; ok {
+			return conflict
+		}
+	}
+	return ""
+}
+
+// deleteWorkspaces deletes the claims of the workspaces reclaimed when the workflow finishes. Only
+// the claims controlled by the workflow are deleted.
+func (r *WorkflowReconciler) deleteWorkspaces(ctx context.Context, workflow *skyv1alpha1.Workflow) error {
+	logger := log.FromContext(ctx)
+
+	for _, workspace := range workflow.GetWorkspaces() {
+		if workspace.GetReclaimPolicy() != skyv1alpha1.WorkspaceReclaimDelete {
+			continue
+		}
+		claim := &v1.PersistentVolumeClaim{}
+		key := client.ObjectKey{Namespace: workflow.Namespace, Name: workspaceClaimName(workflow.Name, workspace.Name)}
+		if err := r.APIReader.Get(ctx, key, claim); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !metav1.IsControlledBy(claim, workflow) {
+			logger.Info("Workspace claim is not controlled by the workflow, keeping it", "workspace", workspace.Name, "claim", claim.Name)
+			continue
+		}
+		if err := r.Client.Delete(ctx, claim, client.Preconditions{UID: &claim.UID}); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		logger.Info("Deleted workspace claim", "workspace", workspace.Name, "claim", claim.Name)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Workspaces", func() {
+	workflow := &skyv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "build", Namespace: "default"},
+		Spec: skyv1alpha1.WorkflowSpec{
+			Workspaces: []skyv1alpha1.Workspace{{
+				Name: "source",
+				VolumeClaimTemplate: v1.PersistentVolumeClaimSpec{
+					AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+				},
+			}},
+		},
+	}
+
+	It("should mount the claim of the run into every step", func() {
+		task := skyv1alpha1.Task{
+			Name:       "test",
+			Workspaces: []skyv1alpha1.WorkspaceBinding{{Name: "source", ReadOnly: true}},
+			Steps:      []skyv1alpha1.Step{{Name: "unit", Image: "golang"}, {Name: "e2e", Image: "golang"}},
+		}
+
+		pod, err := generatePod(context.Background(), task, task.Steps, task.Name, "build-test-abcde", nil, workflow)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Spec.Volumes).To(ContainElement(v1.Volume{
+			Name: "workspace-source",
+			VolumeSource: v1.VolumeSource{PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+				ClaimName: "build-source",
+				ReadOnly:  true,
+			}},
+		}))
+		for _, container := range pod.Spec.Containers {
+			Expect(container.VolumeMounts).To(ContainElement(v1.VolumeMount{Name: "workspace-source", MountPath: "/workspace/source", ReadOnly: true}))
+		}
+
+		claim := newWorkspaceClaim(workflow.Spec.Workspaces[0], workflow)
+		Expect(claim.Name).To(Equal("build-source"))
+		Expect(metav1.IsControlledBy(claim, workflow)).To(BeTrue())
+	})
+
+	It("should reject undeclared workspaces", func() {
+		task := skyv1alpha1.Task{Name: "test", Workspaces: []skyv1alpha1.WorkspaceBinding{{Name: "cache"}}}
+		_, _, err := workspaceVolumes(task, workflow)
+		Expect(err).To(MatchError("task test uses undeclared workspace cache"))
+	})
+
+	It("should not use nor delete the claim of another workflow with the same name", func() {
+		// Workflow a with workspace b-c and workflow a-b with workspace c both name their claim a-b-c.
+		other := &skyv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default", UID: "other"}}
+		otherClaim := newWorkspaceClaim(skyv1alpha1.Workspace{Name: "b-c"}, other)
+		owned := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "a-b", Namespace: "default", UID: "owned"},
+			Spec: skyv1alpha1.WorkflowSpec{Workspaces: []skyv1alpha1.Workspace{
+				{Name: "c", ReclaimPolicy: skyv1alpha1.WorkspaceReclaimDelete},
+				{Name: "d", ReclaimPolicy: skyv1alpha1.WorkspaceReclaimDelete},
+			}},
+		}
+		c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(otherClaim).Build()
+		r := &WorkflowReconciler{Client: c, APIReader: c}
+		ctx := context.Background()
+
+		conflicts, err := r.createWorkspaces(ctx, owned)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(Equal(map[string]string{"c": "claim a-b-c of workspace c already exists and is not controlled by the workflow"}))
+		Expect(workspaceConflict(skyv1alpha1.Task{Name: "test", Workspaces: []skyv1alpha1.WorkspaceBinding{{Name: "d"}, {Name: "c"}}}, conflicts)).To(ContainSubstring("claim a-b-c"))
+		Expect(workspaceConflict(skyv1alpha1.Task{Name: "test", Workspaces: []skyv1alpha1.WorkspaceBinding{{Name: "d"}}}, conflicts)).To(BeEmpty())
+
+		conflicts, err = r.createWorkspaces(ctx, owned)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(conflicts).To(HaveLen(1))
+
+		Expect(r.deleteWorkspaces(ctx, owned)).To(Succeed())
+		Expect(r.Client.Get(ctx, client.ObjectKeyFromObject(otherClaim), &v1.PersistentVolumeClaim{})).To(Succeed())
+		err = r.Client.Get(ctx, client.ObjectKey{Namespace: "default", Name: "a-b-d"}, &v1.PersistentVolumeClaim{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+})
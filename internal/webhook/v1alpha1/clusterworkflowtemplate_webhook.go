@@ -0,0 +1,55 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupClusterWorkflowTemplateWebhookWithManager registers the webhook for ClusterWorkflowTemplate in
+// the manager.
+func SetupClusterWorkflowTemplateWebhookWithManager(mgr ctrl.Manager, defaults *WorkflowDefaults) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&skyv1alpha1.ClusterWorkflowTemplate{}).
+		WithDefaulter(&ClusterWorkflowTemplateCustomDefaulter{Defaults: defaults}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-sky-my-domain-v1alpha1-clusterworkflowtemplate,mutating=true,failurePolicy=fail,sideEffects=None,groups=sky.my.domain,resources=clusterworkflowtemplates,verbs=create;update,versions=v1alpha1,name=mclusterworkflowtemplate.kb.io,admissionReviewVersions=v1
+
+// ClusterWorkflowTemplateCustomDefaulter fills in the defaults of cluster workflow templates, the
+// workflows referencing them get the defaults through them.
+type ClusterWorkflowTemplateCustomDefaulter struct {
+	Defaults *WorkflowDefaults
+}
+
+var _ admission.CustomDefaulter = &ClusterWorkflowTemplateCustomDefaulter{}
+
+// Default implements admission.CustomDefaulter.
+func (d *ClusterWorkflowTemplateCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	template, ok := obj.(*skyv1alpha1.ClusterWorkflowTemplate)
+	if !ok {
+		return fmt.Errorf("expected a ClusterWorkflowTemplate object but got %T", obj)
+	}
+	d.Defaults.applyToTemplate(&template.Spec)
+	return nil
+}
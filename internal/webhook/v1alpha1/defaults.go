@@ -0,0 +1,132 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"os"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"github.com/hq0101/workflow/internal/controller"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// WorkflowDefaults are the settings the defaulting webhooks fill in when workflows and workflow
+// templates leave them unset. They are stamped into the objects, so that users see the effective
+// settings.
+type WorkflowDefaults struct {
+	// TaskTimeout is the timeout of the tasks running a Pod, skyv1alpha1.DefaultTaskTimeout when unset.
+	TaskTimeout *metav1.Duration `json:"taskTimeout,omitempty"`
+	// Image is the image of the steps.
+	Image string `json:"image,omitempty"`
+	// Resources are the requests and limits of the steps, for each resource they do not set.
+	Resources v1.ResourceRequirements `json:"resources,omitempty"`
+	// PodTemplate is merged under the pod template of the workflows.
+	PodTemplate *skyv1alpha1.PodTemplate `json:"podTemplate,omitempty"`
+}
+
+// LoadWorkflowDefaults reads the defaults from a YAML file, an empty path only sets the task timeout.
+func LoadWorkflowDefaults(path string) (*WorkflowDefaults, error) {
+	defaults := &WorkflowDefaults{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the workflow defaults: %v", err)
+		}
+		if _err := yaml.UnmarshalStrict(data, defaults); _err != nil {
+			return nil, fmt.Errorf("failed to parse the workflow defaults %s: %v", path, _err)
+		}
+	}
+	if defaults.TaskTimeout == nil {
+		defaults.TaskTimeout = &metav1.Duration{Duration: skyv1alpha1.DefaultTaskTimeout}
+	}
+	return defaults, nil
+}
+
+// applyToTasks fills in the display names of the tasks and the timeout and steps of the ones
+// running a Pod. Tasks referencing a template are left alone, their fields would override the
+// ones of the template.
+func (d *WorkflowDefaults) applyToTasks(tasks []skyv1alpha1.Task) {
+	for i := range tasks {
+		task := &tasks[i]
+		if task.TemplateRef != nil {
+			continue
+		}
+		if task.DisplayName == "" {
+			task.DisplayName = task.Name
+		}
+		if task.Workflow != nil || task.Approval != nil {
+			continue
+		}
+		if task.Timeout == nil && d.TaskTimeout != nil {
+			task.Timeout = d.TaskTimeout.DeepCopy()
+		}
+		for j := range task.Steps {
+			step := &task.Steps[j]
+			if step.DisplayName == "" {
+				step.DisplayName = step.Name
+			}
+			if step.Image == "" {
+				step.Image = d.Image
+			}
+			d.applyToResources(&step.Resources)
+		}
+	}
+}
+
+// applyToResources adds the default requests and limits of the resources a step does not set. A
+// default request is not added over a limit, nor a default limit under a request.
+func (d *WorkflowDefaults) applyToResources(resources *v1.ResourceRequirements) {
+	for name, quantity := range d.Resources.Requests {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if resources.Requests == nil {
+			resources.Requests = v1.ResourceList{}
+		}
+		resources.Requests[name] = quantity.DeepCopy()
+	}
+	for name, quantity := range d.Resources.Limits {
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if request, ok := resources.Requests[name]; ok && request.Cmp(quantity) > 0 {
+			continue
+		}
+		if resources.Limits == nil {
+			resources.Limits = v1.ResourceList{}
+		}
+		resources.Limits[name] = quantity.DeepCopy()
+	}
+}
+
+// applyToPodTemplate returns the pod template merged over the default one.
+func (d *WorkflowDefaults) applyToPodTemplate(podTemplate *skyv1alpha1.PodTemplate) *skyv1alpha1.PodTemplate {
+	return controller.MergePodTemplates(d.PodTemplate, podTemplate)
+}
+
+// applyToTemplate fills in the defaults of a workflow template.
+func (d *WorkflowDefaults) applyToTemplate(spec *skyv1alpha1.WorkflowTemplateSpec) {
+	d.applyToTasks(spec.Tasks)
+	d.applyToTasks(spec.Finally)
+	spec.PodTemplate = d.applyToPodTemplate(spec.PodTemplate)
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Workflow defaults", func() {
+	var defaults *WorkflowDefaults
+
+	BeforeEach(func() {
+		path := filepath.Join(GinkgoT().TempDir(), "defaults.yaml")
+		Expect(os.WriteFile(path, []byte(`
+taskTimeout: 30m
+image: ubuntu
+resources:
+  requests:
+    cpu: 100m
+    memory: 128Mi
+  limits:
+    memory: 1Gi
+podTemplate:
+  serviceAccountName: ci
+  nodeSelector:
+    node-pool: ci
+`), 0o600)).To(Succeed())
+
+		var err error
+		defaults, err = LoadWorkflowDefaults(path)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should load the defaults", func() {
+		Expect(defaults.TaskTimeout.Duration).To(Equal(30 * time.Minute))
+		Expect(defaults.Image).To(Equal("ubuntu"))
+
+		empty, err := LoadWorkflowDefaults("")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(empty.TaskTimeout.Duration).To(Equal(skyv1alpha1.DefaultTaskTimeout))
+
+		path := filepath.Join(GinkgoT().TempDir(), "defaults.yaml")
+		Expect(os.WriteFile(path, []byte("timeout: 30m\n"), 0o600)).To(Succeed())
+		_, err = LoadWorkflowDefaults(path)
+		Expect(err).To(MatchError(ContainSubstring("failed to parse the workflow defaults")))
+	})
+
+	It("should fill in the unset fields of the tasks", func() {
+		tasks := []skyv1alpha1.Task{
+			{
+				Name:    "build",
+				Timeout: &metav1.Duration{Duration: time.Hour},
+				Steps: []skyv1alpha1.Step{
+					{Name: "compile"},
+					{Name: "test", DisplayName: "unit tests", Image: "golang", Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+						Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("64Mi")},
+					}},
+					{Name: "large", Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("2Gi")},
+					}},
+				},
+			},
+			{Name: "approve", Approval: &skyv1alpha1.Approval{}},
+			{Name: "scan", TemplateRef: &skyv1alpha1.TaskTemplateRef{TemplateRef: skyv1alpha1.TemplateRef{Name: "security"}, Task: "scan"}},
+		}
+		defaults.applyToTasks(tasks)
+
+		build := tasks[0]
+		Expect(build.DisplayName).To(Equal("build"))
+		Expect(build.Timeout.Duration).To(Equal(time.Hour))
+		Expect(build.Steps[0].DisplayName).To(Equal("compile"))
+		Expect(build.Steps[0].Image).To(Equal("ubuntu"))
+		Expect(build.Steps[0].Resources).To(Equal(v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m"), v1.ResourceMemory: resource.MustParse("128Mi")},
+			Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+		}))
+		Expect(build.Steps[1].DisplayName).To(Equal("unit tests"))
+		Expect(build.Steps[1].Image).To(Equal("golang"))
+		Expect(build.Steps[1].Resources).To(Equal(v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2")},
+			Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("64Mi")},
+		}))
+		Expect(build.Steps[2].Resources.Limits).NotTo(HaveKey(v1.ResourceMemory))
+
+		Expect(tasks[1].DisplayName).To(Equal("approve"))
+		Expect(tasks[1].Timeout).To(BeNil())
+		Expect(tasks[2].DisplayName).To(BeEmpty())
+	})
+
+	It("should stamp the defaults into new workflows only", func() {
+		defaulter := &WorkflowCustomDefaulter{Defaults: defaults}
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			PodTemplate: &skyv1alpha1.PodTemplate{NodeSelector: map[string]string{"disk": "ssd"}},
+			Tasks:       []skyv1alpha1.Task{{Name: "build", Steps: []skyv1alpha1.Step{{Name: "compile"}}}},
+		}}
+		update := workflow.DeepCopy()
+
+		ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create}})
+		Expect(defaulter.Default(ctx, workflow)).To(Succeed())
+		Expect(workflow.Spec.Tasks[0].Timeout.Duration).To(Equal(30 * time.Minute))
+		Expect(workflow.Spec.PodTemplate).To(Equal(&skyv1alpha1.PodTemplate{
+			ServiceAccountName: "ci",
+			NodeSelector:       map[string]string{"node-pool": "ci", "disk": "ssd"},
+		}))
+
+		ctx = admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Update}})
+		Expect(defaulter.Default(ctx, update)).To(Succeed())
+		Expect(update.Spec.Tasks[0].Timeout).To(BeNil())
+	})
+
+	It("should leave the pod template to the workflow template", func() {
+		template := &skyv1alpha1.WorkflowTemplate{Spec: skyv1alpha1.WorkflowTemplateSpec{
+			Tasks: []skyv1alpha1.Task{{Name: "build", Steps: []skyv1alpha1.Step{{Name: "compile"}}}},
+		}}
+		Expect((&WorkflowTemplateCustomDefaulter{Defaults: defaults}).Default(context.Background(), template)).To(Succeed())
+		Expect(template.Spec.Tasks[0].Steps[0].Image).To(Equal("ubuntu"))
+		Expect(template.Spec.PodTemplate.ServiceAccountName).To(Equal("ci"))
+
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "build"}}}
+		ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create}})
+		Expect((&WorkflowCustomDefaulter{Defaults: defaults}).Default(ctx, workflow)).To(Succeed())
+		Expect(workflow.Spec.PodTemplate).To(BeNil())
+	})
+})
@@ -0,0 +1,470 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"github.com/hq0101/workflow/internal/controller"
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ApproveVerb is the verb users need on a workflow to decide on its approval tasks.
+const ApproveVerb = "approve"
+
+var workflowlog = logf.Log.WithName("workflow-resource")
+
+// SetupWorkflowWebhookWithManager registers the webhook for Workflow in the manager.
+func SetupWorkflowWebhookWithManager(mgr ctrl.Manager, defaults *WorkflowDefaults) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&skyv1alpha1.Workflow{}).
+		WithDefaulter(&WorkflowCustomDefaulter{Client: mgr.GetClient(), Defaults: defaults}).
+		WithValidator(&WorkflowCustomValidator{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-sky-my-domain-v1alpha1-workflow,mutating=true,failurePolicy=fail,sideEffects=None,groups=sky.my.domain,resources=workflows,verbs=create;update,versions=v1alpha1,name=mworkflow.kb.io,admissionReviewVersions=v1
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// WorkflowCustomDefaulter fills in the defaults of new workflows, and records who decided on their
+// approval tasks after checking they are allowed to.
+type WorkflowCustomDefaulter struct {
+	Client   client.Client
+	Defaults *WorkflowDefaults
+}
+
+var _ admission.CustomDefaulter = &WorkflowCustomDefaulter{}
+
+// Default implements admission.CustomDefaulter.
+func (d *WorkflowCustomDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	workflow, ok := obj.(*skyv1alpha1.Workflow)
+	if !ok {
+		return fmt.Errorf("expected a Workflow object but got %T", obj)
+	}
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	old := &skyv1alpha1.Workflow{}
+	if len(req.OldObject.Raw) != 0 {
+		if _err := json.Unmarshal(req.OldObject.Raw, old); _err != nil {
+			return _err
+		}
+	}
+	// Running workflows keep the defaults they were created with.
+	if req.Operation == admissionv1.Create && d.Defaults != nil {
+		d.applyDefaults(workflow)
+	}
+	return d.recordApprovers(ctx, workflow, old, req.UserInfo)
+}
+
+// applyDefaults fills in the defaults of a workflow. The pod template of a workflow referencing a
+// workflow template is left alone, it would override the one of the template, which has its own
+// defaults.
+func (d *WorkflowCustomDefaulter) applyDefaults(workflow *skyv1alpha1.Workflow) {
+	d.Defaults.applyToTasks(workflow.Spec.Tasks)
+	d.Defaults.applyToTasks(workflow.Spec.Finally)
+	if workflow.Spec.WorkflowTemplateRef == nil {
+		workflow.Spec.PodTemplate = d.Defaults.applyToPodTemplate(workflow.Spec.PodTemplate)
+	}
+}
+
+// recordApprovers stamps the user into the approver annotation of every approval task whose decision
+// changed. Approver annotations of unchanged decisions are restored, users cannot set them.
+func (d *WorkflowCustomDefaulter) recordApprovers(ctx context.Context, workflow, old *skyv1alpha1.Workflow, user authenticationv1.UserInfo) error {
+	for _, taskName := range approvalTaskNames(workflow.Annotations, old.Annotations) {
+		decision := workflow.Annotations[skyv1alpha1.ApprovalAnnotation(taskName)]
+		oldDecision := old.Annotations[skyv1alpha1.ApprovalAnnotation(taskName)]
+		approverKey := skyv1alpha1.ApproverAnnotation(taskName)
+
+		if decision == "" || decision == oldDecision {
+			if oldApprover, ok := old.Annotations[approverKey]; ok && decision != "" {
+				workflow.Annotations[approverKey] = oldApprover
+			} else {
+				delete(workflow.Annotations, approverKey)
+			}
+			continue
+		}
+
+		if err := d.checkApprover(ctx, workflow, old, taskName, decision, user); err != nil {
+			return err
+		}
+		workflow.Annotations[approverKey] = user.Username
+		workflowlog.Info("Recorded approval decision", "workflow", workflow.Name, "task", taskName, "decision", decision, "approver", user.Username)
+	}
+	return nil
+}
+
+// checkApprover checks a decision is valid and the user is allowed to take it.
+func (d *WorkflowCustomDefaulter) checkApprover(ctx context.Context, workflow, old *skyv1alpha1.Workflow, taskName, decision string, user authenticationv1.UserInfo) error {
+	groupResource := schema.GroupResource{Group: skyv1alpha1.GroupVersion.Group, Resource: "workflows"}
+	forbidden := func(format string, args ...any) error {
+		return apierrors.NewForbidden(groupResource, workflow.Name, fmt.Errorf(format, args...))
+	}
+
+	switch skyv1alpha1.ApprovalDecision(decision) {
+	case skyv1alpha1.ApprovalApproved, skyv1alpha1.ApprovalRejected:
+	default:
+		return forbidden("decision %q on task %s is neither %s nor %s", decision, taskName, skyv1alpha1.ApprovalApproved, skyv1alpha1.ApprovalRejected)
+	}
+	approval := approvalOf(workflow, taskName)
+	if approval == nil {
+		return forbidden("task %s is not an approval task", taskName)
+	}
+	if status, ok := old.Status.TaskStatus[taskName]; ok && status.Approval != nil && status.Approval.Decision != "" {
+		return forbidden("task %s was already %s", taskName, status.Approval.Decision)
+	}
+	if len(approval.Groups) != 0 && !slices.ContainsFunc(user.Groups, func(group string) bool { return slices.Contains(approval.Groups, group) }) {
+		return forbidden("user %s is in none of the approver groups %s of task %s", user.Username, strings.Join(approval.Groups, ", "), taskName)
+	}
+
+	review := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  map[string]authorizationv1.ExtraValue{},
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: workflow.Namespace,
+				Verb:      ApproveVerb,
+				Group:     groupResource.Group,
+				Resource:  groupResource.Resource,
+				Name:      workflow.Name,
+			},
+		},
+	}
+	for key, value := range user.Extra {
+		review.Spec.Extra[key] = authorizationv1.ExtraValue(value)
+	}
+	if err := d.Client.Create(ctx, review); err != nil {
+		return fmt.Errorf("failed to review the access of user %s: %v", user.Username, err)
+	}
+	if !review.Status.Allowed {
+		return forbidden("user %s cannot %s workflow %s", user.Username, ApproveVerb, workflow.Name)
+	}
+	return nil
+}
+
+// approvalTaskNames returns the tasks with decision or approver annotations on either version of
+// the workflow.
+func approvalTaskNames(annotations, oldAnnotations map[string]string) []string {
+	names := map[string]bool{}
+	for _, source := range []map[string]string{annotations, oldAnnotations} {
+		for key := range source {
+			name, ok := strings.CutPrefix(key, skyv1alpha1.ApprovalAnnotationPrefix)
+			if !ok || strings.HasSuffix(name, ".comment") {
+				continue
+			}
+			names[strings.TrimSuffix(name, ".approver")] = true
+		}
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// approvalOf returns the approval of a task of the workflow, or of the task an instance expanded
+// from, nil when it is not an approval task.
+func approvalOf(workflow *skyv1alpha1.Workflow, taskName string) *skyv1alpha1.Approval {
+	if status, ok := workflow.Status.TaskStatus[taskName]; ok && status.Parent != "" {
+		taskName = status.Parent
+	}
+	for _, task := range slices.Concat(workflow.GetTasks(), workflow.GetFinally()) {
+		if task.Name == taskName {
+			return task.Approval
+		}
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-sky-my-domain-v1alpha1-workflow,mutating=false,failurePolicy=fail,sideEffects=None,groups=sky.my.domain,resources=workflows,verbs=create;update,versions=v1alpha1,name=vworkflow.kb.io,admissionReviewVersions=v1
+
+// WorkflowCustomValidator rejects the workflows the controller would fail at once, so that they are
+// never stored.
+type WorkflowCustomValidator struct{}
+
+var _ admission.CustomValidator = &WorkflowCustomValidator{}
+
+// ValidateCreate implements admission.CustomValidator.
+func (v *WorkflowCustomValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	workflow, ok := obj.(*skyv1alpha1.Workflow)
+	if !ok {
+		return nil, fmt.Errorf("expected a Workflow object but got %T", obj)
+	}
+	return nil, validateWorkflow(workflow)
+}
+
+// ValidateUpdate implements admission.CustomValidator. Workflows whose spec did not change are
+// accepted, so that stored workflows can still be cancelled or approved.
+func (v *WorkflowCustomValidator) ValidateUpdate(_ context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	workflow, ok := newObj.(*skyv1alpha1.Workflow)
+	if !ok {
+		return nil, fmt.Errorf("expected a Workflow object but got %T", newObj)
+	}
+	old, ok := oldObj.(*skyv1alpha1.Workflow)
+	if !ok {
+		return nil, fmt.Errorf("expected a Workflow object but got %T", oldObj)
+	}
+	if equality.Semantic.DeepEqual(old.Spec, workflow.Spec) {
+		return nil, nil
+	}
+	return nil, validateWorkflow(workflow)
+}
+
+// ValidateDelete implements admission.CustomValidator.
+func (v *WorkflowCustomValidator) ValidateDelete(_ context.Context, _ runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateWorkflow(workflow *skyv1alpha1.Workflow) error {
+	var errs field.ErrorList
+	// The name labels the Pods, ConfigMaps and claims of the workflow, label values are limited to
+	// 63 characters.
+	if len(workflow.Name) > validation.LabelValueMaxLength {
+		errs = append(errs, field.TooLong(field.NewPath("metadata", "name"), workflow.Name, validation.LabelValueMaxLength))
+	}
+	errs = append(errs, validateChildNames(workflow)...)
+	errs = append(errs, validateWorkflowSpec(&workflow.Spec, field.NewPath("spec"))...)
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(skyv1alpha1.GroupVersion.WithKind(skyv1alpha1.KindName).GroupKind(), workflow.Name, errs)
+}
+
+// validateChildNames checks the child workflows of the tasks get names short enough for a label
+// value. They are named after the workflow and the task, or its last instance when the items are
+// known; the instances of parameters are only known once the controller expanded them.
+func validateChildNames(workflow *skyv1alpha1.Workflow) field.ErrorList {
+	var errs field.ErrorList
+	check := func(task skyv1alpha1.Task, taskPath *field.Path) {
+		if task.Workflow == nil {
+			return
+		}
+		name := fmt.Sprintf("%s-%s", workflow.Name, task.Name)
+		if len(task.WithItems) != 0 {
+			name = fmt.Sprintf("%s-%d", name, len(task.WithItems)-1)
+		}
+		if len(name) > validation.LabelValueMaxLength {
+			errs = append(errs, field.Invalid(taskPath.Child("name"), task.Name,
+				fmt.Sprintf("child workflow %s must be no more than %d characters", name, validation.LabelValueMaxLength)))
+		}
+	}
+	for i, task := range workflow.Spec.Tasks {
+		check(task, field.NewPath("spec", "tasks").Index(i))
+	}
+	for i, task := range workflow.Spec.Finally {
+		check(task, field.NewPath("spec", "finally").Index(i))
+	}
+	return errs
+}
+
+// referencePattern matches the placeholders of workflow inputs and task outputs.
+var referencePattern = regexp.MustCompile(`{{((inputs|tasks)\.[^{}]*)}}`)
+
+// validateWorkflowSpec checks the tasks of a workflow can run: unique names, known dependencies
+// without cycles, affordable when expressions, defined references and valid steps. The tasks and inputs of a workflow template
+// are only known once the controller resolved it, the checks needing them are skipped when the
+// workflow references one.
+func validateWorkflowSpec(spec *skyv1alpha1.WorkflowSpec, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	fromTemplate := spec.WorkflowTemplateRef != nil
+
+	tasks := map[string]skyv1alpha1.Task{}
+	taskPaths := map[string]*field.Path{}
+	forEachTask := func(visit func(task skyv1alpha1.Task, taskPath *field.Path)) {
+		for i, task := range spec.Tasks {
+			visit(task, path.Child("tasks").Index(i))
+		}
+		for i, task := range spec.Finally {
+			visit(task, path.Child("finally").Index(i))
+		}
+	}
+	forEachTask(func(task skyv1alpha1.Task, taskPath *field.Path) {
+		if _, ok := tasks[task.Name]; ok {
+			errs = append(errs, field.Duplicate(taskPath.Child("name"), task.Name))
+			return
+		}
+		tasks[task.Name] = task
+		taskPaths[task.Name] = taskPath
+	})
+	inputs := map[string]bool{}
+	for _, input := range spec.Inputs {
+		inputs[input.Name] = true
+	}
+	references := referenceValidator{inputs: inputs, tasks: tasks, fromTemplate: fromTemplate}
+
+	forEachTask(func(task skyv1alpha1.Task, taskPath *field.Path) {
+		for name, fanOut := range tasks {
+			if fanOut.IsFanOut() && controller.IsInstanceName(task.Name, name) {
+				errs = append(errs, field.Invalid(taskPath.Child("name"), task.Name,
+					fmt.Sprintf("the name is taken by the instances of fanned-out task %s", name)))
+			}
+		}
+		dependencies := map[string]bool{}
+		for j, dependency := range task.Dependencies {
+			dependencyPath := taskPath.Child("dependencies").Index(j).Child("name")
+			if _, ok := tasks[dependency.Name]; !ok && !fromTemplate {
+				errs = append(errs, field.NotFound(dependencyPath, dependency.Name))
+			}
+			if dependencies[dependency.Name] {
+				errs = append(errs, field.Duplicate(dependencyPath, dependency.Name))
+			}
+			dependencies[dependency.Name] = true
+		}
+
+		if task.When != "" {
+			if err := controller.ValidateWhen(task.When, len(spec.Inputs), len(tasks)); err != nil {
+				errs = append(errs, field.Invalid(taskPath.Child("when"), task.When, err.Error()))
+			}
+		}
+		errs = append(errs, validateSteps(task, taskPath)...)
+		for k, step := range task.Steps {
+			stepPath := taskPath.Child("steps").Index(k)
+			errs = append(errs, references.validate(stepPath.Child("args"), step.Args)...)
+			errs = append(errs, references.validate(stepPath.Child("script"), step.Script)...)
+			for e, env := range step.Env {
+				errs = append(errs, references.validate(stepPath.Child("env").Index(e).Child("value"), env.Value)...)
+			}
+		}
+		errs = append(errs, references.validate(taskPath.Child("withParam"), task.WithParam)...)
+		if task.Artifacts != nil {
+			for a, artifact := range task.Artifacts.Inputs {
+				// The instances of a fanned-out task upload their artifacts under their own names.
+				if artifact.From != nil && tasks[artifact.From.Task].IsFanOut() {
+					errs = append(errs, field.Invalid(taskPath.Child("artifacts", "inputs").Index(a).Child("from", "task"), artifact.From.Task,
+						fmt.Sprintf("task %s is fanned out, its artifacts cannot be downloaded", artifact.From.Task)))
+				}
+			}
+		}
+		if task.Workflow != nil {
+			for k, input := range task.Workflow.Inputs {
+				errs = append(errs, references.validate(taskPath.Child("workflow", "inputs").Index(k).Child("value"), input.Value)...)
+			}
+		}
+	})
+	for k, output := range spec.Outputs {
+		errs = append(errs, references.validate(path.Child("outputs").Index(k).Child("value"), output.Value)...)
+	}
+
+	// The graph is only complete without the errors above.
+	if len(errs) == 0 && !fromTemplate {
+		dag, err := controller.BuildDAG(slices.Concat(spec.Tasks, spec.Finally))
+		if err != nil {
+			return append(errs, field.Invalid(path.Child("tasks"), nil, err.Error()))
+		}
+		if cycle := dag.Cycle(); cycle != nil {
+			errs = append(errs, field.Invalid(taskPaths[cycle[0]].Child("dependencies"), cycle[1],
+				fmt.Sprintf("dependency cycle detected: %s", strings.Join(cycle, " -> "))))
+		}
+	}
+	return errs
+}
+
+// validateSteps checks a task running a Pod has steps whose names are valid container names.
+func validateSteps(task skyv1alpha1.Task, taskPath *field.Path) field.ErrorList {
+	if task.TemplateRef != nil || task.Workflow != nil || task.Approval != nil {
+		return nil
+	}
+	if len(task.Steps) == 0 {
+		return field.ErrorList{field.Required(taskPath.Child("steps"), "a task needs steps unless it runs a workflow or waits for an approval")}
+	}
+
+	var errs field.ErrorList
+	containers := map[string]bool{controller.InitContainerName: true}
+	for k, step := range task.Steps {
+		namePath := taskPath.Child("steps").Index(k).Child("name")
+		for _, msg := range validation.IsDNS1123Label(step.Name) {
+			errs = append(errs, field.Invalid(namePath, step.Name, msg))
+		}
+		if containers[step.Name] {
+			errs = append(errs, field.Duplicate(namePath, step.Name))
+		}
+		containers[step.Name] = true
+		if step.Image == "" {
+			errs = append(errs, field.Required(taskPath.Child("steps").Index(k).Child("image"), "no default image is configured"))
+		}
+	}
+	for k, sidecar := range task.Sidecars {
+		if containers[sidecar.Name] {
+			errs = append(errs, field.Duplicate(taskPath.Child("sidecars").Index(k).Child("name"), sidecar.Name))
+		}
+		containers[sidecar.Name] = true
+	}
+	return errs
+}
+
+// referenceValidator checks the inputs and task outputs referenced by placeholders are defined.
+type referenceValidator struct {
+	inputs       map[string]bool
+	tasks        map[string]skyv1alpha1.Task
+	fromTemplate bool
+}
+
+func (v referenceValidator) validate(path *field.Path, value string) field.ErrorList {
+	var errs field.ErrorList
+	for _, match := range referencePattern.FindAllStringSubmatch(value, -1) {
+		if name, ok := strings.CutPrefix(match[1], "inputs."); ok {
+			if !v.inputs[name] && !v.fromTemplate {
+				errs = append(errs, field.Invalid(path, match[0], fmt.Sprintf("input %s is not defined", name)))
+			}
+			continue
+		}
+
+		taskName, outputName, ok := strings.Cut(strings.TrimPrefix(match[1], "tasks."), ".outputs.")
+		if !ok {
+			errs = append(errs, field.Invalid(path, match[0], "task outputs are referenced as {{tasks.<task>.outputs.<output>}}"))
+			continue
+		}
+		task, ok := v.tasks[taskName]
+		if !ok {
+			if !v.fromTemplate {
+				errs = append(errs, field.Invalid(path, match[0], fmt.Sprintf("task %s is not defined", taskName)))
+			}
+			continue
+		}
+		// The outputs of templates and child workflows are only known when the task runs.
+		if task.TemplateRef != nil || task.Workflow != nil {
+			continue
+		}
+		if !slices.ContainsFunc(task.Outputs, func(output skyv1alpha1.TaskOutput) bool { return output.Name == outputName }) {
+			errs = append(errs, field.Invalid(path, match[0], fmt.Sprintf("task %s has no output %s", taskName, outputName)))
+		}
+	}
+	return errs
+}
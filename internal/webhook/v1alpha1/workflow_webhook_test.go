@@ -0,0 +1,294 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+)
+
+var _ = Describe("Workflow webhook", func() {
+	var (
+		defaulter *WorkflowCustomDefaulter
+		reviews   []*authorizationv1.SubjectAccessReview
+		old       *skyv1alpha1.Workflow
+	)
+
+	BeforeEach(func() {
+		reviews = nil
+		defaulter = &WorkflowCustomDefaulter{Client: fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+			Create: func(_ context.Context, _ client.WithWatch, obj client.Object, _ ...client.CreateOption) error {
+				review := obj.(*authorizationv1.SubjectAccessReview)
+				review.Status.Allowed = review.Spec.User == "alice"
+				reviews = append(reviews, review)
+				return nil
+			},
+		}).Build()}
+		old = &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: "release", Namespace: "default"},
+			Spec: skyv1alpha1.WorkflowSpec{Tasks: []skyv1alpha1.Task{
+				{Name: "build"},
+				{Name: "approve", Approval: &skyv1alpha1.Approval{Groups: []string{"release-managers"}}},
+			}},
+		}
+	})
+
+	decide := func(workflow *skyv1alpha1.Workflow, user string) error {
+		raw, err := json.Marshal(old)
+		Expect(err).NotTo(HaveOccurred())
+		ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: admissionv1.Update,
+			UserInfo:  authenticationv1.UserInfo{Username: user, Groups: []string{"release-managers"}},
+			OldObject: runtime.RawExtension{Raw: raw},
+		}})
+		return defaulter.Default(ctx, workflow)
+	}
+
+	It("should record the approver of a decision", func() {
+		workflow := old.DeepCopy()
+		workflow.Annotations = map[string]string{
+			skyv1alpha1.ApprovalAnnotation("approve"): "Approved",
+			skyv1alpha1.ApproverAnnotation("approve"): "mallory",
+		}
+
+		Expect(decide(workflow, "alice")).To(Succeed())
+		Expect(workflow.Annotations[skyv1alpha1.ApproverAnnotation("approve")]).To(Equal("alice"))
+		Expect(reviews).To(HaveLen(1))
+		Expect(*reviews[0].Spec.ResourceAttributes).To(Equal(authorizationv1.ResourceAttributes{
+			Namespace: "default", Verb: ApproveVerb, Group: "sky.my.domain", Resource: "workflows", Name: "release",
+		}))
+	})
+
+	It("should keep the recorded approver of an unchanged decision", func() {
+		old.Annotations = map[string]string{
+			skyv1alpha1.ApprovalAnnotation("approve"): "Approved",
+			skyv1alpha1.ApproverAnnotation("approve"): "alice",
+		}
+		workflow := old.DeepCopy()
+		workflow.Annotations[skyv1alpha1.ApproverAnnotation("approve")] = "mallory"
+
+		Expect(decide(workflow, "mallory")).To(Succeed())
+		Expect(workflow.Annotations[skyv1alpha1.ApproverAnnotation("approve")]).To(Equal("alice"))
+		Expect(reviews).To(BeEmpty())
+
+		delete(workflow.Annotations, skyv1alpha1.ApprovalAnnotation("approve"))
+		Expect(decide(workflow, "mallory")).To(Succeed())
+		Expect(workflow.Annotations).NotTo(HaveKey(skyv1alpha1.ApproverAnnotation("approve")))
+	})
+
+	It("should deny decisions users are not allowed to take", func() {
+		workflow := old.DeepCopy()
+		workflow.Annotations = map[string]string{skyv1alpha1.ApprovalAnnotation("approve"): "Approved"}
+		err := decide(workflow, "mallory")
+		Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("user mallory cannot approve workflow release"))
+
+		workflow.Annotations = map[string]string{skyv1alpha1.ApprovalAnnotation("approve"): "Maybe"}
+		Expect(decide(workflow, "alice")).To(MatchError(ContainSubstring(`decision "Maybe" on task approve is neither Approved nor Rejected`)))
+
+		workflow.Annotations = map[string]string{skyv1alpha1.ApprovalAnnotation("build"): "Approved"}
+		Expect(decide(workflow, "alice")).To(MatchError(ContainSubstring("task build is not an approval task")))
+
+		workflow.Annotations = map[string]string{skyv1alpha1.ApprovalAnnotation("approve"): "Approved"}
+		workflow.Spec.Tasks[1].Approval.Groups = []string{"admins"}
+		Expect(decide(workflow, "alice")).To(MatchError(ContainSubstring("user alice is in none of the approver groups admins of task approve")))
+	})
+
+	It("should deny changing a decision the controller observed", func() {
+		old.Annotations = map[string]string{skyv1alpha1.ApprovalAnnotation("approve"): "Rejected"}
+		old.Status.TaskStatus = map[string]skyv1alpha1.TaskStatus{
+			"approve": {Name: "approve", Approval: &skyv1alpha1.ApprovalStatus{Decision: skyv1alpha1.ApprovalRejected}},
+		}
+		workflow := old.DeepCopy()
+		workflow.Annotations[skyv1alpha1.ApprovalAnnotation("approve")] = "Approved"
+
+		Expect(decide(workflow, "alice")).To(MatchError(ContainSubstring("task approve was already Rejected")))
+	})
+})
+
+var _ = Describe("Workflow validation", func() {
+	step := func(name, script string) skyv1alpha1.Step {
+		return skyv1alpha1.Step{Name: name, Image: "ubuntu", Script: script}
+	}
+
+	errorsOf := func(workflow *skyv1alpha1.Workflow) []string {
+		_, err := (&WorkflowCustomValidator{}).ValidateCreate(context.Background(), workflow)
+		if err == nil {
+			return nil
+		}
+		status, ok := err.(apierrors.APIStatus)
+		Expect(ok).To(BeTrue())
+		var messages []string
+		for _, cause := range status.Status().Details.Causes {
+			messages = append(messages, cause.Field+": "+cause.Message)
+		}
+		return messages
+	}
+
+	It("should accept a valid workflow", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Inputs: []skyv1alpha1.Input{{Name: "revision"}},
+			Tasks: []skyv1alpha1.Task{
+				{Name: "build", Steps: []skyv1alpha1.Step{step("compile", "make {{inputs.revision}}")}, Outputs: []skyv1alpha1.TaskOutput{{Name: "image"}}},
+				{Name: "approve", Approval: &skyv1alpha1.Approval{}, Dependencies: []skyv1alpha1.Dependency{{Name: "build"}}},
+			},
+			Finally: []skyv1alpha1.Task{
+				{Name: "report", Steps: []skyv1alpha1.Step{step("report", "echo {{tasks.build.outputs.image}} {{workflow.status}}")}},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(BeEmpty())
+	})
+
+	It("should report every error with its field", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Tasks: []skyv1alpha1.Task{
+				{Name: "build", Steps: []skyv1alpha1.Step{step("Compile", "make {{inputs.revision}}"), {Name: "init-step"}}},
+				{Name: "build", Steps: []skyv1alpha1.Step{step("test", "")}},
+				{Name: "deploy", Dependencies: []skyv1alpha1.Dependency{{Name: "package"}}, Steps: []skyv1alpha1.Step{step("deploy", "{{tasks.build.outputs.image}} {{tasks.lint.outputs.report}}")}},
+				{Name: "lint"},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(ConsistOf(
+			`spec.tasks[1].name: Duplicate value: "build"`,
+			`spec.tasks[0].steps[0].name: Invalid value: "Compile": a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character (e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?')`,
+			`spec.tasks[0].steps[0].script: Invalid value: "{{inputs.revision}}": input revision is not defined`,
+			`spec.tasks[0].steps[1].name: Duplicate value: "init-step"`,
+			`spec.tasks[0].steps[1].image: Required value: no default image is configured`,
+			`spec.tasks[2].dependencies[0].name: Not found: "package"`,
+			`spec.tasks[2].steps[0].script: Invalid value: "{{tasks.build.outputs.image}}": task build has no output image`,
+			`spec.tasks[2].steps[0].script: Invalid value: "{{tasks.lint.outputs.report}}": task lint has no output report`,
+			`spec.tasks[3].steps: Required value: a task needs steps unless it runs a workflow or waits for an approval`,
+		))
+	})
+
+	It("should reject names too long for a label value", func() {
+		workflow := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 64)},
+			Spec:       skyv1alpha1.WorkflowSpec{Tasks: []skyv1alpha1.Task{{Name: "build", Steps: []skyv1alpha1.Step{step("run", "")}}}},
+		}
+		Expect(errorsOf(workflow)).To(ConsistOf(`metadata.name: Too long: must have at most 63 bytes`))
+
+		workflow.Name = strings.Repeat("a", 63)
+		Expect(errorsOf(workflow)).To(BeEmpty())
+	})
+
+	It("should reject tasks whose child workflow names are too long for a label value", func() {
+		child := &skyv1alpha1.SubWorkflow{WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "service"}}
+		workflow := &skyv1alpha1.Workflow{
+			ObjectMeta: metav1.ObjectMeta{Name: strings.Repeat("a", 56)},
+			Spec: skyv1alpha1.WorkflowSpec{Tasks: []skyv1alpha1.Task{
+				{Name: "deploy", Workflow: child},
+				{Name: "build", Workflow: child, WithItems: []string{"api", "web"}},
+				{Name: "release", Workflow: child},
+			}},
+		}
+		Expect(errorsOf(workflow)).To(ConsistOf(
+			`spec.tasks[1].name: Invalid value: "build": child workflow `+workflow.Name+`-build-1 must be no more than 63 characters`,
+			`spec.tasks[2].name: Invalid value: "release": child workflow `+workflow.Name+`-release must be no more than 63 characters`,
+		))
+	})
+
+	It("should reject dependency cycles", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Tasks: []skyv1alpha1.Task{
+				{Name: "a", Dependencies: []skyv1alpha1.Dependency{{Name: "b"}}, Steps: []skyv1alpha1.Step{step("run", "")}},
+				{Name: "b", Dependencies: []skyv1alpha1.Dependency{{Name: "a"}}, Steps: []skyv1alpha1.Step{step("run", "")}},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(ConsistOf(`spec.tasks[0].dependencies: Invalid value: "b": dependency cycle detected: a -> b -> a`))
+	})
+
+	It("should reject tasks named like the instances of a fanned-out task", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Tasks: []skyv1alpha1.Task{
+				{Name: "build", WithItems: []string{"amd64", "arm64"}, Steps: []skyv1alpha1.Step{step("run", "make {{item}}")}},
+				{Name: "build-1", Steps: []skyv1alpha1.Step{step("run", "")}},
+				{Name: "build-docs", Steps: []skyv1alpha1.Step{step("run", "")}},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(ConsistOf(`spec.tasks[1].name: Invalid value: "build-1": the name is taken by the instances of fanned-out task build`))
+	})
+
+	It("should reject input artifacts from fanned-out tasks", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Tasks: []skyv1alpha1.Task{
+				{Name: "compile", WithItems: []string{"amd64", "arm64"}, Steps: []skyv1alpha1.Step{step("run", "make {{item}}")}},
+				{Name: "test", Steps: []skyv1alpha1.Step{step("run", "")}, Artifacts: &skyv1alpha1.TaskArtifacts{
+					Inputs: []skyv1alpha1.Artifact{{Name: "binary", Path: "/bin/app", From: &skyv1alpha1.ArtifactSource{Task: "compile", Artifact: "binary"}}},
+				}},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(ConsistOf(`spec.tasks[1].artifacts.inputs[0].from.task: Invalid value: "compile": task compile is fanned out, its artifacts cannot be downloaded`))
+	})
+
+	It("should reject when expressions exceeding the cost limit", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			Inputs: []skyv1alpha1.Input{{Name: "branch"}},
+			Tasks: []skyv1alpha1.Task{
+				{Name: "build", When: `inputs.branch == "main"`, Steps: []skyv1alpha1.Step{step("run", "")}},
+				{Name: "test", When: `inputs.branch.matches("^release-") && [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(a, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(b,
+					[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(c, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(d,
+					[0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(e, [0, 1, 2, 3, 4, 5, 6, 7, 8, 9].all(f, a + b + c + d + e + f >= 0))))))`,
+					Steps: []skyv1alpha1.Step{step("run", "")}},
+			},
+		}}
+		messages := errorsOf(workflow)
+		Expect(messages).To(HaveLen(1))
+		Expect(messages[0]).To(HavePrefix("spec.tasks[1].when: Invalid value: "))
+		Expect(messages[0]).To(ContainSubstring("exceeds the limit of 1000000"))
+	})
+
+	It("should leave the references of templates to the controller", func() {
+		workflow := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{
+			WorkflowTemplateRef: &skyv1alpha1.TemplateRef{Name: "build"},
+			Tasks: []skyv1alpha1.Task{
+				{Name: "deploy", Dependencies: []skyv1alpha1.Dependency{{Name: "build"}}, Steps: []skyv1alpha1.Step{step("deploy", "{{inputs.revision}} {{tasks.build.outputs.image}}")}},
+				{Name: "scan", TemplateRef: &skyv1alpha1.TaskTemplateRef{TemplateRef: skyv1alpha1.TemplateRef{Name: "security"}, Task: "scan"}},
+			},
+		}}
+		Expect(errorsOf(workflow)).To(BeEmpty())
+	})
+
+	It("should only validate changed specs on update", func() {
+		old := &skyv1alpha1.Workflow{Spec: skyv1alpha1.WorkflowSpec{Tasks: []skyv1alpha1.Task{{Name: "lint"}}}}
+		workflow := old.DeepCopy()
+		workflow.Annotations = map[string]string{"note": "stored before the webhook"}
+		_, err := (&WorkflowCustomValidator{}).ValidateUpdate(context.Background(), old, workflow)
+		Expect(err).NotTo(HaveOccurred())
+
+		workflow.Spec.Tasks[0].Name = "test"
+		_, err = (&WorkflowCustomValidator{}).ValidateUpdate(context.Background(), old, workflow)
+		Expect(err).To(HaveOccurred())
+	})
+})
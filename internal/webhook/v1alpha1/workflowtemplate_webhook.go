@@ -0,0 +1,54 @@
+/*
+Copyright 2024 hq0101.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+
+	skyv1alpha1 "github.com/hq0101/workflow/api/v1alpha1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// SetupWorkflowTemplateWebhookWithManager registers the webhook for WorkflowTemplate in the manager.
+func SetupWorkflowTemplateWebhookWithManager(mgr ctrl.Manager, defaults *WorkflowDefaults) error {
+	return ctrl.NewWebhookManagedBy(mgr).For(&skyv1alpha1.WorkflowTemplate{}).
+		WithDefaulter(&WorkflowTemplateCustomDefaulter{Defaults: defaults}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-sky-my-domain-v1alpha1-workflowtemplate,mutating=true,failurePolicy=fail,sideEffects=None,groups=sky.my.domain,resources=workflowtemplates,verbs=create;update,versions=v1alpha1,name=mworkflowtemplate.kb.io,admissionReviewVersions=v1
+
+// WorkflowTemplateCustomDefaulter fills in the defaults of workflow templates, the workflows
+// referencing them get the defaults through them.
+type WorkflowTemplateCustomDefaulter struct {
+	Defaults *WorkflowDefaults
+}
+
+var _ admission.CustomDefaulter = &WorkflowTemplateCustomDefaulter{}
+
+// Default implements admission.CustomDefaulter.
+func (d *WorkflowTemplateCustomDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	template, ok := obj.(*skyv1alpha1.WorkflowTemplate)
+	if !ok {
+		return fmt.Errorf("expected a WorkflowTemplate object but got %T", obj)
+	}
+	d.Defaults.applyToTemplate(&template.Spec)
+	return nil
+}